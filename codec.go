@@ -0,0 +1,392 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+)
+
+var (
+	// ErrBadFormat is returned when decoding data which is not a valid encoded map.
+	ErrBadFormat = errors.New("intmap: invalid encoding")
+
+	// ErrVersion is returned when decoding a map encoded with an unsupported version.
+	ErrVersion = errors.New("intmap: unsupported encoding version")
+
+	// ErrFillFactor is returned when decoding a map whose fill factor is incompatible
+	// with the shrink policy of the destination map.
+	ErrFillFactor = errors.New("intmap: fill factor incompatible with the shrink policy")
+)
+
+// Every encoding starts with a fixed-size, little-endian header made of a magic
+// number, the format version, the kind of encoding, the widths in bytes of the keys
+// and values and the fill factor of the map.
+const (
+	headerMagic   = "imap"
+	headerVersion = 1
+	headerSize    = 12
+)
+
+// Kinds of encodings, recorded in the header
+const (
+	kindKeys = iota + 1
+	kindCompact
+	kindPortable
+	kindBinary
+)
+
+// appendHeader appends the encoding header for the map to the buffer.
+func (m *Map) appendHeader(dst []byte, kind, valueWidth byte) []byte {
+	dst = append(dst, headerMagic...)
+	dst = append(dst, headerVersion, kind, 4, valueWidth)
+	return binary.LittleEndian.AppendUint32(dst, math.Float32bits(m.fillFactor))
+}
+
+// readHeader validates the encoding header and returns the fill factor as well as
+// the remainder of the data.
+func readHeader(data []byte, kind, valueWidth byte) (float64, []byte, error) {
+	switch {
+	case len(data) < headerSize:
+		return 0, nil, io.ErrUnexpectedEOF
+	case string(data[0:4]) != headerMagic:
+		return 0, nil, ErrBadFormat
+	case data[4] != headerVersion:
+		return 0, nil, ErrVersion
+	case data[5] != kind || data[6] != 4 || data[7] != valueWidth:
+		return 0, nil, ErrBadFormat
+	}
+
+	fill := float64(math.Float32frombits(binary.LittleEndian.Uint32(data[8:12])))
+	if !(fill > 0 && fill < 1) {
+		return 0, nil, ErrBadFormat
+	}
+
+	return fill, data[headerSize:], nil
+}
+
+// MarshalKeys encodes only the keys of the map, dropping the values. This is
+// useful when the map is used as a set, as it halves the encoded size.
+func (m *Map) MarshalKeys() []byte {
+	out := make([]byte, 0, headerSize+4+4*m.Count())
+	out = m.appendHeader(out, kindKeys, 0)
+	out = binary.LittleEndian.AppendUint32(out, uint32(m.Count()))
+	m.RangeEach(func(key, _ uint32) {
+		out = binary.LittleEndian.AppendUint32(out, key)
+	})
+	return out
+}
+
+// UnmarshalKeys decodes a map previously encoded with MarshalKeys. Since only
+// the keys were encoded, every key is assigned the provided value.
+func UnmarshalKeys(data []byte, value uint32) (*Map, error) {
+	fill, data, err := readHeader(data, kindKeys, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	count := int(binary.LittleEndian.Uint32(data))
+	if data = data[4:]; len(data) < 4*count {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	m := New(max(count, 1), fill)
+	for i := 0; i < count; i++ {
+		m.Store(binary.LittleEndian.Uint32(data[4*i:]), value)
+	}
+	return m, nil
+}
+
+// MarshalCompact encodes the map in ascending key order, with the keys delta-encoded
+// and the values run-length encoded. This is significantly smaller than the regular
+// encoding when many consecutive keys share the same value, such as flags.
+func (m *Map) MarshalCompact() []byte {
+	entries := m.sorted()
+	out := make([]byte, 0, headerSize+4+2*len(entries))
+	out = m.appendHeader(out, kindCompact, 4)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(entries)))
+
+	// Write the keys as deltas from the previous key
+	prev := uint32(0)
+	for _, e := range entries {
+		key := uint32(e >> 32)
+		out = binary.AppendUvarint(out, uint64(key-prev))
+		prev = key
+	}
+
+	// Write the values as (value, length) runs
+	for i := 0; i < len(entries); {
+		run := 1
+		for i+run < len(entries) && uint32(entries[i+run]) == uint32(entries[i]) {
+			run++
+		}
+
+		out = binary.AppendUvarint(out, uint64(uint32(entries[i])))
+		out = binary.AppendUvarint(out, uint64(run))
+		i += run
+	}
+	return out
+}
+
+// UnmarshalCompact decodes a map previously encoded with MarshalCompact.
+func UnmarshalCompact(data []byte) (*Map, error) {
+	fill, data, err := readHeader(data, kindCompact, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	// Each key and run takes at least a byte, bail early on truncated input
+	count := int(binary.LittleEndian.Uint32(data))
+	if data = data[4:]; len(data) < count {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	keys := make([]uint32, count)
+	prev := uint64(0)
+	for i := range keys {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		prev += delta
+		keys[i] = uint32(prev)
+		data = data[n:]
+	}
+
+	m := New(max(count, 1), fill)
+	for i := 0; i < count; {
+		value, n1 := binary.Uvarint(data)
+		if n1 <= 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		run, n2 := binary.Uvarint(data[n1:])
+		if n2 <= 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if run == 0 || run > uint64(count-i) || value > math.MaxUint32 {
+			return nil, ErrBadFormat
+		}
+
+		for _, key := range keys[i : i+int(run)] {
+			m.Store(key, uint32(value))
+		}
+
+		i += int(run)
+		data = data[n1+n2:]
+	}
+	return m, nil
+}
+
+// MarshalPortable encodes the key/value pairs of the map in ascending key order, as
+// little-endian integers. Unlike the backing array, the encoding does not depend on
+// the hash function or the layout of the map, so it remains valid across versions.
+func (m *Map) MarshalPortable() []byte {
+	entries := m.sorted()
+	out := make([]byte, 0, headerSize+4+8*len(entries))
+	out = m.appendHeader(out, kindPortable, 4)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(entries)))
+	for _, e := range entries {
+		out = binary.LittleEndian.AppendUint32(out, uint32(e>>32))
+		out = binary.LittleEndian.AppendUint32(out, uint32(e))
+	}
+	return out
+}
+
+// UnmarshalPortable decodes a map previously encoded with MarshalPortable.
+func UnmarshalPortable(data []byte) (*Map, error) {
+	fill, data, err := readHeader(data, kindPortable, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	count := int(binary.LittleEndian.Uint32(data))
+	if data = data[4:]; len(data) < 8*count {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	m := New(max(count, 1), fill)
+	for i := 0; i < count; i++ {
+		m.Store(binary.LittleEndian.Uint32(data[8*i:]), binary.LittleEndian.Uint32(data[8*i+4:]))
+	}
+	return m, nil
+}
+
+// SerializedSize returns the exact number of bytes produced by MarshalBinary, so that
+// a buffer can be allocated ahead of time.
+func (m *Map) SerializedSize() int {
+	pairs := m.Count()
+	if m.Contains(0) {
+		pairs-- // stored in the header
+	}
+	return headerSize + 9 + 8*pairs
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. After the header, the encoding
+// holds the number of pairs, whether the key zero is present and its value, followed
+// by every other key/value pair as little-endian integers, in no particular order.
+func (m *Map) MarshalBinary() ([]byte, error) {
+	return m.AppendBinary(make([]byte, 0, m.SerializedSize()))
+}
+
+// AppendBinary appends the encoding of MarshalBinary to the buffer and returns the
+// extended buffer.
+func (m *Map) AppendBinary(dst []byte) ([]byte, error) {
+	zero, hasZero := m.Load(0)
+	pairs := m.Count()
+	if hasZero {
+		pairs--
+	}
+
+	dst = m.appendHeader(dst, kindBinary, 4)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(pairs))
+	if hasZero {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+
+	dst = binary.LittleEndian.AppendUint32(dst, zero)
+	m.ForEach(func(key, value uint32) {
+		if key != 0 {
+			dst = binary.LittleEndian.AppendUint32(dst, key)
+			dst = binary.LittleEndian.AppendUint32(dst, value)
+		}
+	})
+	return dst, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler and decodes a map previously
+// encoded with MarshalBinary, replacing the contents of the map. The map is resized to
+// fit the decoded entries, so it does not retain any excess capacity. The options of
+// the map are kept, and a zero map is initialized with the default options.
+// ErrFillFactor is returned if the encoded fill factor is incompatible with the
+// SetShrinkPolicy of the map, in which case the map is left unchanged.
+func (m *Map) UnmarshalBinary(data []byte) error {
+	fill, data, err := readHeader(data, kindBinary, 4)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < 9 {
+		return io.ErrUnexpectedEOF
+	}
+
+	count := int(binary.LittleEndian.Uint32(data))
+	hasZero, zero := data[4], binary.LittleEndian.Uint32(data[5:])
+	switch data = data[9:]; {
+	case hasZero > 1:
+		return ErrBadFormat
+	case len(data) < 8*count:
+		return io.ErrUnexpectedEOF
+	}
+
+	// Same constraint as SetFillFactor, the map would otherwise shrink right away
+	if float64(m.minLoad) >= fill/2 {
+		return fmt.Errorf("%w, %.2f must be above twice the shrink load %.2f", ErrFillFactor, fill, m.minLoad)
+	}
+
+	m.reset(count+int(hasZero), fill)
+	if hasZero == 1 {
+		m.Store(0, zero)
+	}
+
+	for i := 0; i < count; i++ {
+		m.Store(binary.LittleEndian.Uint32(data[8*i:]), binary.LittleEndian.Uint32(data[8*i+4:]))
+	}
+	return nil
+}
+
+// CanMarshalJSON returns whether the map round-trips through MarshalJSON and
+// UnmarshalJSON without loss. This is always the case, since every uint32 key has a
+// decimal form and every uint32 value is exactly representable as a JSON number, but
+// it allows callers to check it in the same way as for more constrained formats.
+func (m *Map) CanMarshalJSON() bool {
+	return true
+}
+
+// MarshalJSON implements json.Marshaler and encodes the map as a JSON object, with the
+// keys in ascending order as decimal strings and the values as numbers.
+func (m *Map) MarshalJSON() ([]byte, error) {
+	entries := m.sorted()
+	out := make([]byte, 0, 2+16*len(entries))
+	out = append(out, '{')
+	for i, e := range entries {
+		if i > 0 {
+			out = append(out, ',')
+		}
+
+		out = append(out, '"')
+		out = strconv.AppendUint(out, e>>32, 10)
+		out = append(out, '"', ':')
+		out = strconv.AppendUint(out, uint64(uint32(e)), 10)
+	}
+	return append(out, '}'), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler and decodes a JSON object encoded by
+// MarshalJSON, replacing the contents of the map. The map is resized to fit the
+// decoded entries, keeping its options and fill factor, and a zero map is initialized
+// with the default options.
+func (m *Map) UnmarshalJSON(data []byte) error {
+	var src map[uint32]uint32
+	if err := json.Unmarshal(data, &src); err != nil {
+		return err
+	}
+
+	fill := float64(m.fillFactor)
+	if m.step == 0 {
+		fill = .9
+	}
+
+	m.reset(len(src), fill)
+	for key, value := range src {
+		m.Store(key, value)
+	}
+	return nil
+}
+
+// reset clears the map and resizes it to fit the given number of entries with the
+// fill factor. A zero map, which was not created with New, is initialized instead.
+func (m *Map) reset(size int, fill float64) {
+	if m.step == 0 {
+		*m = *New(max(size, 1), fill)
+		return
+	}
+
+	m.Clear()
+	m.fillFactor = float32(fill)
+	m.resize(arraySize(size, fill))
+}
+
+// sorted returns the entries of the map packed as key<<32 | value, in ascending
+// order of their keys.
+func (m *Map) sorted() []uint64 {
+	out := make([]uint64, 0, m.Count())
+	m.RangeEach(func(key, value uint32) {
+		out = append(out, uint64(key)<<32|uint64(value))
+	})
+
+	slices.Sort(out)
+	return out
+}
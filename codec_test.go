@@ -0,0 +1,287 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalKeys(t *testing.T) {
+	m := sequentialMap(100)
+	data := m.MarshalKeys()
+	assert.Len(t, data, headerSize+4+4*100)
+
+	out, err := UnmarshalKeys(data, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, out.Count())
+	for i := uint32(0); i < 100; i++ {
+		v, ok := out.Load(i)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(1), v)
+	}
+}
+
+func TestUnmarshalKeysInvalid(t *testing.T) {
+	_, err := UnmarshalKeys([]byte{1, 2}, 1)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	data := sequentialMap(10).MarshalKeys()
+	_, err = UnmarshalKeys(data[:len(data)-1], 1)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = UnmarshalKeys(make([]byte, 8), 1)
+	assert.Error(t, err)
+}
+
+func TestMarshalCompact(t *testing.T) {
+	m := New(1000, .9)
+	for i := uint32(0); i < 1000; i++ {
+		m.Store(i, i/100)
+	}
+
+	data := m.MarshalCompact()
+	assert.Less(t, len(data), 8*1000/2)
+
+	out, err := UnmarshalCompact(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, out.Count())
+	for i := uint32(0); i < 1000; i++ {
+		v, ok := out.Load(i)
+		assert.True(t, ok)
+		assert.Equal(t, i/100, v)
+	}
+}
+
+func TestMarshalCompactRandom(t *testing.T) {
+	m := randomMap(1000)
+	m.Store(0, 123)
+
+	out, err := UnmarshalCompact(m.MarshalCompact())
+	assert.NoError(t, err)
+	assert.Equal(t, m.Count(), out.Count())
+	m.Range(func(key, value uint32) bool {
+		v, ok := out.Load(key)
+		assert.True(t, ok)
+		assert.Equal(t, value, v)
+		return true
+	})
+}
+
+func TestMarshalCompactEmpty(t *testing.T) {
+	out, err := UnmarshalCompact(New(10, .9).MarshalCompact())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, out.Count())
+}
+
+func TestUnmarshalCompactInvalid(t *testing.T) {
+	data := sequentialMap(100).MarshalCompact()
+	for i := 0; i < len(data); i++ {
+		_, err := UnmarshalCompact(data[:i])
+		assert.Error(t, err)
+	}
+
+	_, err := UnmarshalCompact(make([]byte, 8))
+	assert.Error(t, err)
+}
+
+func TestMarshalPortable(t *testing.T) {
+	m := randomMap(1000)
+	m.Store(0, 5)
+
+	// The encoding does not depend on the layout of the map
+	data := m.MarshalPortable()
+	clone := New(10, .9)
+	m.RangeEach(clone.Store)
+	assert.Equal(t, data[headerSize:], clone.MarshalPortable()[headerSize:])
+
+	// Keys are in ascending order
+	prev := -1
+	for i := headerSize + 4; i < len(data); i += 8 {
+		key := int(binary.LittleEndian.Uint32(data[i:]))
+		assert.Greater(t, key, prev)
+		prev = key
+	}
+
+	out, err := UnmarshalPortable(data)
+	assert.NoError(t, err)
+	assert.Equal(t, m.sorted(), out.sorted())
+}
+
+func TestUnmarshalPortableInvalid(t *testing.T) {
+	data := sequentialMap(10).MarshalPortable()
+	for i := 0; i < len(data); i++ {
+		_, err := UnmarshalPortable(data[:i])
+		assert.Error(t, err)
+	}
+
+	_, err := UnmarshalPortable(sequentialMap(10).MarshalCompact())
+	assert.Equal(t, ErrBadFormat, err)
+}
+
+func TestSerializedSize(t *testing.T) {
+	m := New(8, .9)
+	assert.Equal(t, headerSize+9, m.SerializedSize())
+
+	// The key zero is stored in the header
+	m.Store(0, 1)
+	assert.Equal(t, headerSize+9, m.SerializedSize())
+
+	for i := uint32(1); i <= 100; i++ {
+		m.Store(i, i)
+	}
+
+	data, err := m.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, headerSize+9+800, m.SerializedSize())
+	assert.Len(t, data, m.SerializedSize())
+}
+
+func TestCanMarshalJSON(t *testing.T) {
+	m := New(8, .9)
+	m.Store(0, math.MaxUint32)
+	m.Store(math.MaxUint32, 0)
+	assert.True(t, m.CanMarshalJSON())
+
+	data, err := m.MarshalJSON()
+	assert.NoError(t, err)
+	out := new(Map)
+	assert.NoError(t, out.UnmarshalJSON(data))
+	assert.True(t, m.Equal(out))
+}
+
+func TestMarshalBinary(t *testing.T) {
+	for _, size := range []int{0, 1, 1000} {
+		m := New(8, .9)
+		for i := 0; i < size; i++ {
+			m.Store(rand.Uint32(), uint32(i))
+		}
+		if size > 0 {
+			m.Store(0, 5)
+		}
+
+		data, err := m.MarshalBinary()
+		assert.NoError(t, err)
+		assert.Len(t, data, m.SerializedSize())
+		assert.Equal(t, m.SerializedSize(), cap(data))
+
+		var out Map
+		assert.NoError(t, out.UnmarshalBinary(data))
+		assert.NoError(t, out.Validate())
+		assert.True(t, m.Equal(&out))
+		assert.Equal(t, arraySize(max(m.Count(), 1), .9), out.Capacity())
+	}
+}
+
+func TestUnmarshalBinaryOptions(t *testing.T) {
+	src := New(8, .9)
+	for i := uint32(0); i < 100; i++ {
+		src.Store(i, i)
+	}
+	src.Store(math.MaxUint32, 1)
+	data, _ := src.MarshalBinary()
+
+	// The decoded map keeps its options and drops its excess capacity
+	m := New(100000, .5, WithProbeStride(3), WithPresenceIndex())
+	m.Store(12345, 1)
+	assert.NoError(t, m.UnmarshalBinary(data))
+	assert.NoError(t, m.Validate())
+	assert.True(t, src.Equal(m))
+	assert.Equal(t, uint32(6), m.step)
+	assert.Equal(t, arraySize(101, .9), m.Capacity())
+	assert.Equal(t, float32(.9), m.fillFactor)
+}
+
+func TestUnmarshalBinaryShrinkPolicy(t *testing.T) {
+	src := New(8, .3)
+	src.Store(1, 1)
+	data, _ := src.MarshalBinary()
+
+	m := New(8, .9)
+	m.SetShrinkPolicy(.4)
+	m.Store(2, 2)
+	assert.ErrorIs(t, m.UnmarshalBinary(data), ErrFillFactor)
+	assert.Equal(t, float32(.9), m.fillFactor)
+	got, ok := m.Load(2)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(2), got)
+
+	m.SetShrinkPolicy(.1)
+	assert.NoError(t, m.UnmarshalBinary(data))
+	assert.True(t, src.Equal(m))
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	data, _ := sequentialMap(10).MarshalBinary()
+	for i := 0; i < len(data); i++ {
+		assert.Error(t, new(Map).UnmarshalBinary(data[:i]))
+	}
+
+	bad := slices.Clone(data)
+	bad[headerSize+4] = 2
+	assert.Equal(t, ErrBadFormat, new(Map).UnmarshalBinary(bad))
+	assert.Equal(t, ErrBadFormat, new(Map).UnmarshalBinary(sequentialMap(10).MarshalPortable()))
+}
+
+func TestMarshalJSON(t *testing.T) {
+	m := New(8, .9)
+	data, err := json.Marshal(m)
+	assert.NoError(t, err)
+	assert.Equal(t, `{}`, string(data))
+
+	m.Store(10, 1)
+	m.Store(0, 2)
+	m.Store(math.MaxUint32, 3)
+	data, err = json.Marshal(m)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"0":2,"10":1,"4294967295":3}`, string(data))
+
+	var out struct{ Counts *Map }
+	assert.NoError(t, json.Unmarshal([]byte(`{"Counts":`+string(data)+`}`), &out))
+	assert.True(t, m.Equal(out.Counts))
+	assert.Equal(t, 8, out.Counts.Capacity())
+}
+
+func TestUnmarshalJSONInvalid(t *testing.T) {
+	for _, data := range []string{`[]`, `{"a":1}`, `{"1":-1}`, `{"4294967296":1}`, `{"1":`} {
+		assert.Error(t, new(Map).UnmarshalJSON([]byte(data)), data)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	m := sequentialMap(10)
+	data := m.MarshalKeys()
+	assert.Equal(t, []byte("imap"), data[0:4])
+	assert.Equal(t, []byte{headerVersion, kindKeys, 4, 0}, data[4:8])
+
+	// Mismatched magic
+	bad := slices.Clone(data)
+	bad[0] = 'x'
+	_, err := UnmarshalKeys(bad, 1)
+	assert.Equal(t, ErrBadFormat, err)
+
+	// Unsupported version
+	bad = slices.Clone(data)
+	bad[4] = headerVersion + 1
+	_, err = UnmarshalKeys(bad, 1)
+	assert.Equal(t, ErrVersion, err)
+
+	// Invalid fill factor
+	bad = slices.Clone(data)
+	copy(bad[8:12], []byte{0, 0, 0, 0})
+	_, err = UnmarshalKeys(bad, 1)
+	assert.Equal(t, ErrBadFormat, err)
+
+	// Different kind of encoding
+	_, err = UnmarshalKeys(m.MarshalCompact(), 1)
+	assert.Equal(t, ErrBadFormat, err)
+	_, err = UnmarshalCompact(data)
+	assert.Equal(t, ErrBadFormat, err)
+}
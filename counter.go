@@ -0,0 +1,34 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+// Counter is a map-like data-structure of uint32 keys to uint64 counts. It uses the
+// same probing as Map, but with wider values so that large counts do not wrap.
+type Counter struct {
+	Table[uint32, uint64]
+}
+
+// NewCounter returns a counter initialized with n spaces and uses the stated fillFactor.
+// The counter will grow as needed.
+func NewCounter(size int, fillFactor float64) *Counter {
+	return &Counter{
+		Table: *NewTable[uint32, uint64](size, fillFactor),
+	}
+}
+
+// Increment adds the delta to the count of a key and returns the new count. If the
+// key is not present, its count starts from zero.
+func (c *Counter) Increment(key uint32, delta uint64) uint64 {
+	value := c.upsert(key)
+	*value += delta
+	return *value
+}
+
+// SumValues returns the sum of all of the counts.
+func (c *Counter) SumValues() (sum uint64) {
+	c.Range(func(_ uint32, value uint64) bool {
+		sum += value
+		return true
+	})
+	return
+}
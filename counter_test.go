@@ -0,0 +1,41 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter(8, .9)
+	for i := 0; i < 10; i++ {
+		for k := uint32(0); k < 1000; k++ {
+			c.Increment(k, uint64(k))
+		}
+	}
+
+	assert.Equal(t, 1000, c.Count())
+	for k := uint32(0); k < 1000; k++ {
+		v, ok := c.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, uint64(10*k), v)
+	}
+
+	assert.Equal(t, uint64(10*999*1000/2), c.SumValues())
+}
+
+func TestCounterOverflow(t *testing.T) {
+	c := NewCounter(8, .9)
+	assert.Equal(t, uint64(math.MaxUint32), c.Increment(1, math.MaxUint32))
+	assert.Equal(t, uint64(math.MaxUint32)+10, c.Increment(1, 10))
+	assert.Equal(t, uint64(math.MaxUint32), c.Increment(0, math.MaxUint32))
+	assert.Equal(t, 2*uint64(math.MaxUint32)+10, c.SumValues())
+
+	c.Delete(1)
+	_, ok := c.Load(1)
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Count())
+}
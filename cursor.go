@@ -0,0 +1,56 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+// Cursor iterates over the entries of a map by pulling them one at a time, which lets
+// the iteration be interleaved with other work, such as merging several maps. The
+// cursor is invalidated if the map is modified, after which it must be Reset.
+type Cursor struct {
+	m     *Map   // Map being iterated
+	pos   int    // Next position in the backing array, negative before the 'free' key
+	key   uint32 // Current key
+	value uint32 // Current value
+}
+
+// Cursor returns a cursor positioned before the first entry of the map.
+func (m *Map) Cursor() *Cursor {
+	return &Cursor{m: m, pos: -1}
+}
+
+// Next advances the cursor to the next entry and returns whether there was one.
+func (c *Cursor) Next() bool {
+	m := c.m
+	if c.pos < 0 {
+		c.pos = 0
+		if m.hasFreeKey {
+			c.key, c.value = isFree, m.freeVal
+			return true
+		}
+	}
+
+	i := m.next(c.pos)
+	if i >= len(m.data) {
+		c.pos = i
+		return false
+	}
+
+	c.key, c.value = m.data[i], m.data[i+1]
+	c.pos = i + 2
+	return true
+}
+
+// Key returns the key of the current entry.
+func (c *Cursor) Key() uint32 {
+	return c.key
+}
+
+// Value returns the value of the current entry.
+func (c *Cursor) Value() uint32 {
+	return c.value
+}
+
+// Reset positions the cursor back before the first entry of the map.
+func (c *Cursor) Reset() {
+	c.pos = -1
+	c.key, c.value = 0, 0
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	m := sequentialMap(1000)
+	c := m.Cursor()
+
+	sum, count := 0, 0
+	for c.Next() {
+		assert.Equal(t, c.Key(), c.Value())
+		sum += int(c.Key())
+		count++
+	}
+
+	assert.Equal(t, 1000, count)
+	assert.Equal(t, 999*1000/2, sum)
+	assert.False(t, c.Next())
+
+	// Reset starts over, including the 'free' key
+	c.Reset()
+	assert.True(t, c.Next())
+	assert.Equal(t, uint32(0), c.Key())
+}
+
+func TestCursorEmpty(t *testing.T) {
+	c := New(8, .9).Cursor()
+	assert.False(t, c.Next())
+	assert.False(t, c.Next())
+}
+
+func TestCursorMerge(t *testing.T) {
+	a, b := New(8, .9), New(8, .9)
+	for i := uint32(0); i < 100; i++ {
+		a.Store(i, 1)
+		b.Store(i+1000, 2)
+	}
+
+	// Interleave both cursors until they are exhausted
+	ca, cb := a.Cursor(), b.Cursor()
+	sum := uint32(0)
+	for okA, okB := ca.Next(), cb.Next(); okA || okB; okA, okB = ca.Next(), cb.Next() {
+		if okA {
+			sum += ca.Value()
+		}
+		if okB {
+			sum += cb.Value()
+		}
+	}
+	assert.Equal(t, uint32(300), sum)
+}
+
+/*
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkCursor-8   	     412	   2769313 ns/op	       0 B/op	       0 allocs/op
+*/
+func BenchmarkCursor(b *testing.B) {
+	m := sequentialMap(1000000)
+	sum := uint32(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for c := m.Cursor(); c.Next(); {
+			sum += c.Value()
+		}
+	}
+}
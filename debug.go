@@ -0,0 +1,15 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+//go:build intmap_debug
+
+package intmap
+
+import "fmt"
+
+// assertCount panics if the count of the map differs from the expected one. It is
+// only enabled with the intmap_debug build tag, since it is not free.
+func (m *Map) assertCount(expect int32) {
+	if m.count != expect {
+		panic(fmt.Sprintf("intmap: count is %d after resize, expected %d", m.count, expect))
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+// Hasher computes the home bucket of a key, which is where the map starts probing
+// for it. Bucket must return a slot in the range [0, mask], where mask is the capacity
+// of the map minus one, and must always return the same slot for the same key and mask.
+type Hasher interface {
+	Bucket(key, mask uint32) uint32
+}
+
+// DefaultHasher is the hasher used by the map unless another one is provided.
+type DefaultHasher struct{}
+
+// Bucket returns the home bucket of the key, as used by the map by default.
+func (DefaultHasher) Bucket(key, mask uint32) uint32 {
+	return bucketOf(key, mask) >> 1
+}
+
+// MixHasher is a hasher which mixes every bit of the key with the finalizer of the
+// 32-bit MurmurHash3. It is slower than the default hasher, which only relies on the
+// low bits of the key being well distributed, but avoids clustering on structured
+// keys, such as keys which are all multiples of a power of two.
+type MixHasher struct{}
+
+// Bucket returns the home bucket of the key.
+func (MixHasher) Bucket(key, mask uint32) uint32 {
+	key ^= key >> 16
+	key *= 0x85ebca6b
+	key ^= key >> 13
+	key *= 0xc2b2ae35
+	key ^= key >> 16
+	return key & mask
+}
+
+// WithHasher replaces the hash function used to place the keys in the map. Every
+// operation of the map, including growing and deleting, goes through the hasher so
+// it can be used to experiment with custom hash functions and measure their probing.
+func WithHasher(h Hasher) Option {
+	return func(m *Map) {
+		if _, ok := h.(DefaultHasher); !ok {
+			m.hasher = h
+		}
+	}
+}
+
+// NewWithHasher returns a map initialized with n spaces and uses the stated fillFactor
+// and hasher. The map will grow as needed.
+func NewWithHasher(size int, fillFactor float64, h Hasher) *Map {
+	return New(size, fillFactor, WithHasher(h))
+}
+
+// bucketWith returns the position of the home bucket of the key in the backing array
+// using the custom hasher, masking the result in case the hasher returns a slot out
+// of range. Call sites check for the hasher first, so that the default path remains
+// inlined.
+//
+//go:noinline
+func (m *Map) bucketWith(key uint32) uint32 {
+	return (m.hasher.Bucket(key, m.mask[0]) & m.mask[0]) << 1
+}
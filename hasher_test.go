@@ -0,0 +1,100 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// identityHasher places every key in the slot given by its lowest bits
+type identityHasher struct{}
+
+func (identityHasher) Bucket(key, mask uint32) uint32 {
+	return key & mask
+}
+
+// constantHasher places every key in the same bucket, out of range on purpose
+type constantHasher struct{}
+
+func (constantHasher) Bucket(key, mask uint32) uint32 {
+	return 0xffffffff
+}
+
+func TestDefaultHasher(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		key := rand.Uint32()
+		assert.Equal(t, HomeBucket(key, 1024), int(DefaultHasher{}.Bucket(key, 1023)))
+	}
+
+	m := NewWithHasher(8, .9, DefaultHasher{})
+	assert.Nil(t, m.hasher)
+}
+
+func TestHasher(t *testing.T) {
+	for _, h := range []Hasher{identityHasher{}, constantHasher{}, MixHasher{}} {
+		m := NewWithHasher(8, .9, h)
+		ref := make(map[uint32]uint32)
+		for i := 0; i < 20000; i++ {
+			key := rand.Uint32N(1000)
+			if rand.IntN(3) == 0 {
+				m.Delete(key)
+				delete(ref, key)
+				continue
+			}
+
+			m.Store(key, uint32(i))
+			ref[key] = uint32(i)
+		}
+
+		assert.NoError(t, m.Validate())
+		assert.Equal(t, len(ref), m.Count())
+		for k, v := range ref {
+			got, ok := m.Load(k)
+			assert.True(t, ok)
+			assert.Equal(t, v, got)
+		}
+
+		m.RangeSlots(func(slot int, key, _ uint32, probe int) bool {
+			if slot >= 0 {
+				home := int(h.Bucket(key, uint32(m.Capacity()-1))) & (m.Capacity() - 1)
+				assert.Equal(t, slot, (home+probe)&(m.Capacity()-1))
+			}
+			return true
+		})
+	}
+}
+
+func TestMixHasher(t *testing.T) {
+	const count = 10000
+	next := func(i uint32) uint32 { return i << 8 }
+
+	// The default hash only spreads the low bits, so multiples of 256 cluster
+	home := make(map[int]struct{})
+	for i := uint32(0); i < count; i++ {
+		home[HomeBucket(next(i), 16384)] = struct{}{}
+	}
+	assert.LessOrEqual(t, len(home), 64)
+
+	clear(home)
+	for i := uint32(0); i < count; i++ {
+		home[int(MixHasher{}.Bucket(next(i), 16383))] = struct{}{}
+	}
+	assert.Greater(t, len(home), 7000)
+
+	// Probe distances stay short with the mixing hasher
+	m := NewWithHasher(count, .75, MixHasher{})
+	for i := uint32(0); i < count; i++ {
+		m.Store(next(i), i)
+	}
+
+	sum := 0
+	m.RangeSlots(func(_ int, _, _ uint32, probe int) bool {
+		sum += probe
+		return true
+	})
+	assert.LessOrEqual(t, float64(sum)/count, 2.0)
+	assert.NoError(t, m.Validate())
+}
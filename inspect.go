@@ -0,0 +1,250 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// RangeSlots calls fn sequentially for each key and value present in the map, along
+// with the slot the entry occupies in the backing array and its probe distance, the
+// number of probes from its home bucket to the slot. The 'free' key is not stored in
+// the backing array and is reported first with a slot of -1. If fn returns false, the
+// iteration stops.
+func (m *Map) RangeSlots(fn func(slot int, key, val uint32, probe int) bool) {
+	if m.hasFreeKey && !fn(-1, isFree, m.freeVal, 0) {
+		return
+	}
+
+	for i := 0; i < len(m.data); i += 2 {
+		if k := m.data[i]; k != isFree {
+			if !fn(i/2, k, m.data[i+1], m.probeOf(uint32(i))) {
+				return
+			}
+		}
+	}
+}
+
+// RangeByBucket calls fn for each key and value present in the map, ordered by the
+// home bucket of the keys rather than by the slot they occupy, and by probe distance
+// within a bucket. This differs from the slot order when keys are displaced, which is
+// useful to analyze clustering. The 'free' key is not stored in the backing array and
+// is reported first with a bucket of 0. If fn returns false, the iteration stops.
+func (m *Map) RangeByBucket(fn func(bucket int, key, val uint32) bool) {
+	if m.hasFreeKey && !fn(0, isFree, m.freeVal) {
+		return
+	}
+
+	// Sort the entries by their home bucket, then by their probe distance
+	order := make([]uint64, 0, m.Count())
+	for i := 0; i < len(m.data); i += 2 {
+		if m.data[i] != isFree {
+			probe := m.probeOf(uint32(i))
+			home := (uint32(i) - uint32(probe)*m.step) & m.mask[1]
+			order = append(order, uint64(home>>1)<<32|uint64(probe))
+		}
+	}
+
+	slices.Sort(order)
+	for _, v := range order {
+		home, probe := uint32(v>>32), uint32(v)
+		ptr := (home<<1 + probe*m.step) & m.mask[1]
+		if !fn(int(home), m.data[ptr], m.data[ptr+1]) {
+			return
+		}
+	}
+}
+
+// HomeBucketCounts returns the number of keys which share each home bucket at the
+// current capacity, for every bucket which is the home of at least one key. Buckets
+// with many keys indicate clustering, which makes the lookups slower. The 'free' key
+// is not stored in the backing array and is not counted.
+func (m *Map) HomeBucketCounts() map[uint32]int {
+	out := make(map[uint32]int)
+	for i := 0; i < len(m.data); i += 2 {
+		if key := m.data[i]; key != isFree {
+			home := bucketOf(key, m.mask[0])
+			if m.hasher != nil {
+				home = m.bucketWith(key)
+			}
+			out[home>>1]++
+		}
+	}
+	return out
+}
+
+// probeOf returns the probe distance of the key stored at the given position.
+func (m *Map) probeOf(ptr uint32) int {
+	home := bucketOf(m.data[ptr], m.mask[0])
+	if m.hasher != nil {
+		home = m.bucketWith(m.data[ptr])
+	}
+	return int(m.distance(ptr, home))
+}
+
+// Validate checks the internal invariants of the map and returns an error describing
+// the first violation found. Every key must be reachable from its home bucket without
+// crossing a free slot, must be stored only once and the number of entries found must
+// match the count of the map. This is meant to be used in tests and for debugging.
+func (m *Map) Validate() error {
+	capacity := int(m.mask[0]) + 1
+	if len(m.data) != 2*capacity || m.mask[1] != 2*m.mask[0]+1 {
+		return fmt.Errorf("intmap: mask %v does not match the capacity %d", m.mask, len(m.data)/2)
+	}
+
+	for i := 0; i < len(m.data); i += 2 {
+		key := m.data[i]
+		if key == isFree {
+			continue
+		}
+
+		if ptr, ok := m.slot(key); !ok || ptr != uint32(i) {
+			return fmt.Errorf("intmap: key %d at slot %d is unreachable or duplicate", key, i/2)
+		}
+	}
+
+	if m.index != nil {
+		for i := 0; i < len(m.data); i += 2 {
+			if used := m.index[i>>7]&(1<<(i>>1&63)) != 0; used != (m.data[i] != isFree) {
+				return fmt.Errorf("intmap: presence index is out of sync at slot %d, see SyncCount", i/2)
+			}
+		}
+	}
+
+	if count := m.RecountLive(); count != int(m.count) {
+		return fmt.Errorf("intmap: found %d entries, but the count is %d, see SyncCount", count, m.count)
+	}
+	return nil
+}
+
+// RecountLive scans the backing array and returns the actual number of entries in the
+// map, without trusting the cached count which is returned by Count.
+func (m *Map) RecountLive() (count int) {
+	if m.hasFreeKey {
+		count++
+	}
+
+	for i := 0; i < len(m.data); i += 2 {
+		if m.data[i] != isFree {
+			count++
+		}
+	}
+	return
+}
+
+// SyncCount recomputes the count of the map, as well as the presence index if the
+// map has one, from the backing array. It must be called after editing the array
+// returned by Raw, since such edits bypass the bookkeeping of the map.
+func (m *Map) SyncCount() {
+	m.count = int32(m.RecountLive())
+	if m.index != nil {
+		clear(m.index)
+		for i := 0; i < len(m.data); i += 2 {
+			if m.data[i] != isFree {
+				m.index[i>>7] |= 1 << (i >> 1 & 63)
+			}
+		}
+	}
+}
+
+// Inspect returns a human-readable report of the internal geometry of the map, such
+// as its capacity, load and the distribution of probe distances, which is helpful
+// when diagnosing performance issues. It does not modify the map.
+func (m *Map) Inspect() string {
+	var sum, longest int
+	var histogram [6]int // 0, 1, 2, 3-4, 5-8, 9+
+	m.RangeSlots(func(slot int, _, _ uint32, probe int) bool {
+		if slot < 0 {
+			return true // the 'free' key is not probed
+		}
+
+		sum += probe
+		longest = max(longest, probe)
+		switch {
+		case probe <= 2:
+			histogram[probe]++
+		case probe <= 4:
+			histogram[3]++
+		case probe <= 8:
+			histogram[4]++
+		default:
+			histogram[5]++
+		}
+		return true
+	})
+
+	stored := m.RecountLive()
+	if m.hasFreeKey {
+		stored--
+	}
+
+	avg := 0.0
+	if stored > 0 {
+		avg = float64(sum) / float64(stored)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "capacity:   %d\n", m.Capacity())
+	fmt.Fprintf(&out, "count:      %d (free key: %v)\n", m.count, m.hasFreeKey)
+	fmt.Fprintf(&out, "load:       %.4f\n", float64(stored)/float64(m.Capacity()))
+	fmt.Fprintf(&out, "threshold:  %d\n", m.threshold)
+	fmt.Fprintf(&out, "fill:       %.4f\n", m.fillFactor)
+	fmt.Fprintf(&out, "mask:       %#x, %#x\n", m.mask[0], m.mask[1])
+	fmt.Fprintf(&out, "probe:      max %d, avg %.3f\n", longest, avg)
+	for i, label := range []string{"0", "1", "2", "3-4", "5-8", "9+"} {
+		fmt.Fprintf(&out, "  %-8s  %d\n", label, histogram[i])
+	}
+	return out.String()
+}
+
+// DiffReport returns a human-readable report of the differences between the map and
+// the other one, with a line per key which was added (+), removed (-) or changed (~)
+// in the other map, sorted by key. At most limit differences are listed, or all of
+// them if limit is not positive. An empty string is returned if both maps are equal.
+func (m *Map) DiffReport(other *Map, limit int) string {
+	type change struct {
+		key, prev, next uint32
+		op              byte
+	}
+
+	var diff []change
+	m.RangeEach(func(key, prev uint32) {
+		switch next, ok := other.Load(key); {
+		case !ok:
+			diff = append(diff, change{key: key, prev: prev, op: '-'})
+		case next != prev:
+			diff = append(diff, change{key: key, prev: prev, next: next, op: '~'})
+		}
+	})
+
+	other.RangeEach(func(key, next uint32) {
+		if _, ok := m.Load(key); !ok {
+			diff = append(diff, change{key: key, next: next, op: '+'})
+		}
+	})
+
+	slices.SortFunc(diff, func(a, b change) int {
+		return cmp.Compare(a.key, b.key)
+	})
+
+	var out strings.Builder
+	for i, d := range diff {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(&out, "... and %d more\n", len(diff)-limit)
+			break
+		}
+
+		switch d.op {
+		case '-':
+			fmt.Fprintf(&out, "- %d: %d\n", d.key, d.prev)
+		case '+':
+			fmt.Fprintf(&out, "+ %d: %d\n", d.key, d.next)
+		default:
+			fmt.Fprintf(&out, "~ %d: %d -> %d\n", d.key, d.prev, d.next)
+		}
+	}
+	return out.String()
+}
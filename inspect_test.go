@@ -0,0 +1,239 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeSlots(t *testing.T) {
+	m := randomMap(1000)
+	m.Store(0, 10)
+
+	count := 0
+	m.RangeSlots(func(slot int, key, val uint32, probe int) bool {
+		count++
+		if key == isFree {
+			assert.Equal(t, -1, slot)
+			assert.Equal(t, uint32(10), val)
+			return true
+		}
+
+		assert.Equal(t, key, m.data[2*slot])
+		assert.Equal(t, val, m.data[2*slot+1])
+		assert.Equal(t, slot, (HomeBucket(key, m.Capacity())+probe)%m.Capacity())
+
+		// Every slot along the probe sequence must be occupied
+		for i := 0; i < probe; i++ {
+			ptr := 2 * ((HomeBucket(key, m.Capacity()) + i) % m.Capacity())
+			assert.NotEqual(t, uint32(isFree), m.data[ptr])
+		}
+		return true
+	})
+	assert.Equal(t, m.Count(), count)
+}
+
+func TestRangeSlotsStop(t *testing.T) {
+	m := sequentialMap(100)
+	count := 0
+	m.RangeSlots(func(slot int, key, val uint32, probe int) bool {
+		count++
+		return false
+	})
+	assert.Equal(t, 1, count)
+}
+
+func TestRangeByBucket(t *testing.T) {
+	for _, stride := range []int{1, 7} {
+		m := New(1000, .99, WithProbeStride(stride))
+		for i := 0; i < 1000; i++ {
+			m.Store(rand.Uint32(), uint32(i))
+		}
+		m.Store(0, 10)
+
+		count, last := 0, -1
+		m.RangeByBucket(func(bucket int, key, val uint32) bool {
+			count++
+			assert.GreaterOrEqual(t, bucket, last)
+			if key == isFree {
+				assert.Equal(t, 0, bucket)
+				assert.Zero(t, count-1, "the 'free' key is reported first")
+			} else {
+				assert.Equal(t, HomeBucket(key, m.Capacity()), bucket)
+			}
+
+			last = bucket
+			return true
+		})
+		assert.Equal(t, m.Count(), count)
+	}
+}
+
+func TestRangeByBucketStop(t *testing.T) {
+	m := randomMap(100)
+	count := 0
+	m.RangeByBucket(func(int, uint32, uint32) bool {
+		count++
+		return count < 10
+	})
+	assert.Equal(t, 10, count)
+}
+
+func TestHomeBucketCounts(t *testing.T) {
+	m := New(1000, .9)
+	for i := uint32(0); i < 1000; i++ {
+		m.Store(i<<8, i) // multiples of 256 cluster with the default hash
+	}
+	m.Store(0, 1)
+
+	counts := m.HomeBucketCounts()
+	total := 0
+	for bucket, n := range counts {
+		assert.Equal(t, uint32(0xb), bucket&0xff) // the increment of the hash
+		total += n
+	}
+
+	assert.Equal(t, 999, total)
+	assert.LessOrEqual(t, len(counts), m.Capacity()/256)
+	assert.Empty(t, New(8, .9).HomeBucketCounts())
+
+	// With a custom hasher, every key shares the last bucket
+	h := NewWithHasher(100, .9, constantHasher{})
+	for i := uint32(1); i <= 50; i++ {
+		h.Store(i, i)
+	}
+	assert.Equal(t, map[uint32]int{h.mask[0]: 50}, h.HomeBucketCounts())
+}
+
+func TestValidate(t *testing.T) {
+	m := randomMap(1000)
+	m.Store(0, 1)
+	assert.NoError(t, m.Validate())
+
+	// Corrupt the count
+	m.count++
+	assert.Error(t, m.Validate())
+	m.count--
+
+	// Duplicate a key into a free slot right after it
+	for i := 0; i < len(m.data); i += 2 {
+		next := (i + 2) % len(m.data)
+		if m.data[i] != isFree && m.data[next] == isFree {
+			m.data[next] = m.data[i]
+			break
+		}
+	}
+	assert.Error(t, m.Validate())
+}
+
+func TestValidateMask(t *testing.T) {
+	m := New(10, .9)
+	m.mask[0] = 1
+	assert.Error(t, m.Validate())
+}
+
+// FuzzMap applies a sequence of operations encoded in the input to both the map and
+// to a standard map, and checks that they agree and that the invariants hold.
+func FuzzMap(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0, 1, 1, 1, 0, 2, 0, 0})
+	f.Add([]byte{0, 1, 0, 0, 2, 0, 0, 0, 3, 0, 1, 1, 1, 0, 1, 0, 0, 2, 0})
+	f.Add(make([]byte, 300))
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		m := New(8, .9)
+		ref := make(map[uint32]uint32)
+		for i := 0; i+3 <= len(ops); i += 3 {
+			key := uint32(ops[i+1]) | uint32(ops[i+2])<<8
+			switch ops[i] % 3 {
+			case 0, 1:
+				m.Store(key, uint32(i))
+				ref[key] = uint32(i)
+			case 2:
+				m.Delete(key)
+				delete(ref, key)
+			}
+		}
+
+		if err := m.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Count() != len(ref) {
+			t.Fatalf("count is %d, expected %d", m.Count(), len(ref))
+		}
+
+		for k, v := range ref {
+			if out, ok := m.Load(k); !ok || out != v {
+				t.Fatalf("key %d has value %d (%v), expected %d", k, out, ok, v)
+			}
+		}
+	})
+}
+
+func TestRecountLive(t *testing.T) {
+	m := randomMap(1000)
+	m.Store(0, 1)
+	assert.Equal(t, m.Count(), m.RecountLive())
+
+	m.count = 10
+	assert.Equal(t, 10, m.Count())
+	assert.Equal(t, len(collect(m)), m.RecountLive())
+}
+
+func TestSyncCount(t *testing.T) {
+	m := New(16, .9, WithPresenceIndex())
+	m.Store(5, 50)
+
+	// Place a key in its empty home bucket directly in the array
+	raw, home := m.Raw(), HomeBucket(7, m.Capacity())
+	assert.Zero(t, raw[2*home])
+	raw[2*home], raw[2*home+1] = 7, 70
+	assert.Error(t, m.Validate())
+
+	m.SyncCount()
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, 2, m.Count())
+	got, ok := m.Load(7)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(70), got)
+
+	// Remove it again, which leaves no other key behind it in the probe sequence
+	raw[2*home], raw[2*home+1] = 0, 0
+	m.SyncCount()
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, 1, m.Count())
+}
+
+func TestInspect(t *testing.T) {
+	m := sequentialMap(100)
+	before := m.Clone()
+	out := m.Inspect()
+
+	assert.Contains(t, out, "capacity:   128\n")
+	assert.Contains(t, out, "count:      100 (free key: true)\n")
+	assert.Contains(t, out, "probe:      max ")
+	assert.Equal(t, before, m)
+}
+
+func TestInspectEmpty(t *testing.T) {
+	out := New(10, .9).Inspect()
+	assert.Contains(t, out, "probe:      max 0, avg 0.000\n")
+}
+
+func TestDiffReport(t *testing.T) {
+	a := New(16, .9)
+	a.Store(0, 1)
+	a.Store(5, 50)
+	a.Store(9, 90)
+
+	b := a.Clone()
+	assert.Empty(t, a.DiffReport(b, 10))
+
+	b.Delete(0)
+	b.Store(9, 91)
+	b.Store(3, 30)
+	assert.Equal(t, "- 0: 1\n+ 3: 30\n~ 9: 90 -> 91\n", a.DiffReport(b, 0))
+	assert.Equal(t, "- 0: 1\n+ 3: 30\n... and 1 more\n", a.DiffReport(b, 2))
+}
@@ -4,12 +4,22 @@
 package intmap
 
 import (
+	"cmp"
+	"errors"
+	"iter"
 	"math"
+	"math/bits"
+	"slices"
+	"sync"
+	"unsafe"
 )
 
 // isFree is the 'free' key
 const isFree = 0
 
+// maxCapacity is the largest capacity for which both masks still fit in a uint32.
+const maxCapacity = 1 << (30 + bits.UintSize/64)
+
 // Map is a map-like data-structure for int64s
 type Map struct {
 	data       []uint32  // Keys and values, interleaved keys
@@ -17,27 +27,101 @@ type Map struct {
 	threshold  int32     // Threshold for resize
 	count      int32     // Number of elements in the map
 	mask       [2]uint32 // Mask to calculate the original bucket and collisions
+	index      []uint64  // Optional bitset of occupied slots
+	minLoad    float32   // Load below which the map shrinks
+	minSize    uint32    // Capacity the map was created with, it never shrinks below
+	hasher     Hasher    // Optional hash function, see WithHasher
+	step       uint32    // Distance between probed positions, see WithProbeStride
+	inverse    uint32    // Multiplicative inverse of the probe stride
+	zeroValues bool      // Whether to zero the values on delete, see WithZeroOnDelete
+	generation uint32    // Incremented whenever the backing array is replaced or cleared
 	freeVal    uint32    // Value of 'free' key
 	hasFreeKey bool      // Whether 'free' key exists
 }
 
 // New returns a map initialized with n spaces and uses the stated fillFactor.
 // The map will grow as needed.
-func New(size int, fillFactor float64) *Map {
-	if fillFactor <= 0 || fillFactor >= 1 {
-		panic("intmap: fill factor must be in (0, 1)")
+func New(size int, fillFactor float64, options ...Option) *Map {
+	capacity := CapacityFor(size, fillFactor)
+	m := &Map{
+		data:       alloc(2 * capacity),
+		fillFactor: float32(fillFactor),
+		threshold:  thresholdOf(capacity, fillFactor),
+		mask:       [2]uint32{uint32(capacity - 1), uint32(2*capacity - 1)},
+		minSize:    uint32(capacity),
+		step:       2,
+		inverse:    1,
 	}
-	if size <= 0 {
-		panic("intmap: size must be positive")
+
+	for _, option := range options {
+		option(m)
 	}
+	return m
+}
 
-	capacity := arraySize(size, fillFactor)
-	return &Map{
-		data:       make([]uint32, 2*capacity),
-		fillFactor: float32(fillFactor),
-		threshold:  int32(math.Floor(float64(capacity) * fillFactor)),
-		mask:       [2]uint32{uint32(capacity - 1), uint32(2*capacity - 1)},
+// FromSorted returns a map sized exactly for the provided keys and values, which must
+// have the same length. Inserting keys sorted by their HomeBucket keeps most of them
+// in their home bucket, which makes this faster than storing them in random order.
+// If a key is repeated, the last value wins.
+func FromSorted(keys, vals []uint32, fillFactor float64) *Map {
+	if len(keys) != len(vals) {
+		panic("intmap: keys and values must have the same length")
 	}
+
+	m := New(max(len(keys), 1), fillFactor)
+	for i, key := range keys {
+		m.Store(key, vals[i])
+	}
+	return m
+}
+
+// Of returns a map sized for the key/value pairs provided, with a fill factor of 0.9.
+// If a key is repeated, the last value wins.
+func Of(pairs ...[2]uint32) *Map {
+	m := New(max(len(pairs), 1), .9)
+	for _, kv := range pairs {
+		m.Store(kv[0], kv[1])
+	}
+	return m
+}
+
+// BuildCounts returns a map of every distinct key to the number of times it occurs
+// in the keys. Since the number of distinct keys is not known up front, the map starts
+// small and grows as needed rather than allocating a slot for every key, and is shrunk
+// at the end if it is larger than needed.
+func BuildCounts(keys []uint32) *Map {
+	m := New(min(max(len(keys), 1), 1024), .9)
+	for _, key := range keys {
+		m.Add(key, 1)
+	}
+
+	m.Maintain()
+	return m
+}
+
+// SumMaps returns a new map where the value of each key is the sum of its values in
+// all of the maps. The sums wrap around on overflow, so a Counter should be used if
+// they may exceed 32 bits. The result is sized for the largest of the maps and uses
+// its fill factor.
+func SumMaps(maps ...*Map) *Map {
+	var largest *Map
+	for _, m := range maps {
+		if largest == nil || m.Count() > largest.Count() {
+			largest = m
+		}
+	}
+
+	if largest == nil {
+		return New(1, .9)
+	}
+
+	out := New(max(largest.Count(), 1), largest.FillFactor())
+	for _, m := range maps {
+		m.ForEach(func(key, value uint32) {
+			out.Add(key, value)
+		})
+	}
+	return out
 }
 
 // Capacity returns the capacity of the map.
@@ -45,6 +129,33 @@ func (m *Map) Capacity() int {
 	return len(m.data) / 2
 }
 
+// Threshold returns the number of entries which triggers the next growth of the map.
+func (m *Map) Threshold() int {
+	return int(m.threshold)
+}
+
+// FillFactor returns the fill factor of the map.
+func (m *Map) FillFactor() float64 {
+	return float64(m.fillFactor)
+}
+
+// Raw returns the backing array of the map, with each slot holding a key followed by
+// its value. Slot i is found at Raw()[2*i] and a key of zero denotes an empty slot,
+// since the 'free' key is stored separately. The array is only valid until the map
+// grows or shrinks.
+// Modifying it directly bypasses the bookkeeping of the map, such as its count, and
+// must preserve the probing invariants checked by Validate. After adding or removing
+// keys through the array, SyncCount must be called before using the map again.
+func (m *Map) Raw() []uint32 {
+	return m.data
+}
+
+// NextSlot returns the slot which follows the given one in the probe sequence. This
+// allows to walk the same probe sequence as the map when scanning the Raw() array.
+func (m *Map) NextSlot(slot int) int {
+	return int((uint32(slot) + m.step>>1) & m.mask[0])
+}
+
 // Load returns the value stored in the map for a key, or nil if no value is
 // present. The ok result indicates whether value was found in the map.
 func (m *Map) Load(key uint32) (uint32, bool) {
@@ -56,10 +167,18 @@ func (m *Map) Load(key uint32) (uint32, bool) {
 	}
 
 	ptr := bucketOf(key, m.mask[0])
+	if m.hasher != nil {
+		ptr = m.bucketWith(key)
+	}
+
 	if ptr < 0 || ptr >= uint32(len(m.data)) { // Check to help to compiler to eliminate a bounds check below.
 		return 0, false
 	}
 
+	if m.index != nil && m.index[ptr>>7]&(1<<(ptr>>1&63)) == 0 {
+		return 0, false // home bucket is empty
+	}
+
 	switch m.data[ptr] {
 	case isFree: // end of chain already
 		return 0, false
@@ -67,7 +186,7 @@ func (m *Map) Load(key uint32) (uint32, bool) {
 		return m.data[ptr+1], true
 	default:
 		for {
-			ptr = (ptr + 2) & m.mask[1]
+			ptr = (ptr + m.step) & m.mask[1]
 			switch m.data[ptr] {
 			case isFree:
 				return 0, false
@@ -78,43 +197,80 @@ func (m *Map) Load(key uint32) (uint32, bool) {
 	}
 }
 
+// GetOrDefault returns the value stored in the map for a key, or def if the key is
+// not present.
+func (m *Map) GetOrDefault(key, def uint32) uint32 {
+	if v, ok := m.Load(key); ok {
+		return v
+	}
+	return def
+}
+
+// Contains returns whether a key is present in the map. It probes the same way as
+// Load, but never reads the value.
+func (m *Map) Contains(key uint32) bool {
+	if key == isFree {
+		return m.hasFreeKey
+	}
+
+	ptr := bucketOf(key, m.mask[0])
+	if m.hasher != nil {
+		ptr = m.bucketWith(key)
+	}
+
+	if ptr < 0 || ptr >= uint32(len(m.data)) { // Check to help to compiler to eliminate a bounds check below.
+		return false
+	}
+
+	if m.index != nil && m.index[ptr>>7]&(1<<(ptr>>1&63)) == 0 {
+		return false // home bucket is empty
+	}
+
+	for {
+		switch m.data[ptr] {
+		case isFree:
+			return false
+		case key:
+			return true
+		}
+		ptr = (ptr + m.step) & m.mask[1]
+	}
+}
+
+// ContainsMany returns, for each of the keys, whether it is present in the map. The
+// result has the same length as the keys.
+func (m *Map) ContainsMany(keys []uint32) []bool {
+	out := make([]bool, len(keys))
+	for i, key := range keys {
+		out[i] = m.Contains(key)
+	}
+	return out
+}
+
 // Store sets the value for a key.
 func (m *Map) Store(key, val uint32) {
 	if key == isFree {
-		if !m.hasFreeKey {
-			m.count++
-		}
-		m.hasFreeKey = true
-		m.freeVal = val
+		m.storeFree(val)
 		return
 	}
 
 	ptr := bucketOf(key, m.mask[0])
+	if m.hasher != nil {
+		ptr = m.bucketWith(key)
+	}
 	switch m.data[ptr] {
 	case isFree: // end of chain already
-		m.data[ptr] = key
-		m.data[ptr+1] = val
-		if m.count >= m.threshold {
-			m.rehash()
-		} else {
-			m.count++
-		}
+		m.insert(ptr, key, val)
 		return
 	case key: // overwrite existed value
 		m.data[ptr+1] = val
 		return
 	default:
 		for {
-			ptr = (ptr + 2) & m.mask[1]
+			ptr = (ptr + m.step) & m.mask[1]
 			switch m.data[ptr] {
 			case isFree:
-				m.data[ptr] = key
-				m.data[ptr+1] = val
-				if m.count >= m.threshold {
-					m.rehash()
-				} else {
-					m.count++
-				}
+				m.insert(ptr, key, val)
 				return
 			case key:
 				m.data[ptr+1] = val
@@ -124,35 +280,521 @@ func (m *Map) Store(key, val uint32) {
 	}
 }
 
-// Delete deletes the value for a key.
-func (m *Map) Delete(key uint32) {
-	if m.hasFreeKey && key == isFree {
-		m.hasFreeKey = false
-		m.count--
-		return
+// LoadOrStore returns the existing value for the key if present. Otherwise, it stores
+// and returns the value returned by fn, which is only called if the key is missing.
+// The loaded result is true if the value was loaded, false if stored. The key is only
+// probed once, so fn must not modify the map.
+func (m *Map) LoadOrStore(key uint32, fn func() uint32) (value uint32, loaded bool) {
+	if key == isFree {
+		if m.hasFreeKey {
+			return m.freeVal, true
+		}
+
+		value = fn()
+		m.storeFree(value)
+		return value, false
 	}
 
-	ptr := bucketOf(key, m.mask[0])
-	switch m.data[ptr] {
-	case isFree: // end of chain already
+	ptr, ok := m.slot(key)
+	if ok {
+		return m.data[ptr+1], true
+	}
+
+	value = fn()
+	m.insert(ptr, key, value)
+	return value, false
+}
+
+// Update sets the value for a key to the result of fn, which is called with the current
+// value and whether the key is present. The slot of the key is located only once, so
+// this is faster than a Load followed by a Store. fn must not modify the map.
+func (m *Map) Update(key uint32, fn func(old uint32, loaded bool) uint32) {
+	if key == isFree {
+		if m.hasFreeKey {
+			m.freeVal = fn(m.freeVal, true)
+		} else {
+			m.storeFree(fn(0, false))
+		}
 		return
-	case key:
-		m.shiftKeys(ptr)
-		m.count--
+	}
+
+	ptr, ok := m.slot(key)
+	if ok {
+		m.data[ptr+1] = fn(m.data[ptr+1], true)
 		return
+	}
+
+	m.insert(ptr, key, fn(0, false))
+}
+
+// StoreReport sets the value for a key and returns whether the map grew as a result,
+// which is useful to track where the resizes happen.
+func (m *Map) StoreReport(key, val uint32) (grew bool) {
+	capacity := len(m.data)
+	m.Store(key, val)
+	return len(m.data) > capacity
+}
+
+// StoreBounded sets the value for a key, unless the key is not yet present and storing
+// it would grow the map, in which case the map is left unchanged and false is returned.
+// This allows to apply back-pressure under a strict memory budget. Since the map never
+// grows in this case, probe lengths increase as the load approaches the fill factor,
+// so the map should be created with a size which accounts for the expected entries.
+func (m *Map) StoreBounded(key, val uint32) bool {
+	if key == isFree { // not stored in the backing array
+		m.storeFree(val)
+		return true
+	}
+
+	ptr, ok := m.slot(key)
+	switch {
+	case ok:
+		m.data[ptr+1] = val
+	case m.count >= m.threshold:
+		return false
 	default:
-		for {
-			ptr = (ptr + 2) & m.mask[1]
-			switch m.data[ptr] {
-			case isFree:
-				return
-			case key:
-				m.shiftKeys(ptr)
-				m.count--
-				return
+		m.insert(ptr, key, val)
+	}
+	return true
+}
+
+// Add adds the delta to the value of a key and returns the new value. If the key is
+// not present, it is stored with the delta as its value. The value wraps on overflow.
+func (m *Map) Add(key, delta uint32) uint32 {
+	if key == isFree {
+		if !m.hasFreeKey {
+			m.storeFree(delta)
+			return delta
+		}
+
+		m.freeVal += delta
+		return m.freeVal
+	}
+
+	ptr, ok := m.slot(key)
+	if !ok {
+		m.insert(ptr, key, delta)
+		return delta
+	}
+
+	m.data[ptr+1] += delta
+	return m.data[ptr+1]
+}
+
+// StoreClamped sets the value for a key, capped to the limit.
+func (m *Map) StoreClamped(key, val, limit uint32) {
+	m.Store(key, min(val, limit))
+}
+
+// IncrementClamped adds the delta to the value of a key and returns the new value,
+// which saturates at the limit instead of wrapping around on overflow. If the key is
+// not present, it is stored with the delta as its value, capped to the limit.
+func (m *Map) IncrementClamped(key, delta, limit uint32) uint32 {
+	if key == isFree {
+		value := min(delta, limit)
+		if m.hasFreeKey {
+			value = saturatingAdd(m.freeVal, delta, limit)
+		}
+
+		m.storeFree(value)
+		return value
+	}
+
+	ptr, ok := m.slot(key)
+	if !ok {
+		m.insert(ptr, key, min(delta, limit))
+		return min(delta, limit)
+	}
+
+	m.data[ptr+1] = saturatingAdd(m.data[ptr+1], delta, limit)
+	return m.data[ptr+1]
+}
+
+// saturatingAdd adds the delta to the value, capping the result to the limit.
+func saturatingAdd(value, delta, limit uint32) uint32 {
+	if sum := value + delta; sum >= value {
+		return min(sum, limit)
+	}
+	return limit // overflow
+}
+
+// StoreMax sets the value for a key if it is greater than the current value, or if
+// the key is not present. This keeps the maximum value seen for every key.
+func (m *Map) StoreMax(key, val uint32) {
+	if key == isFree {
+		if !m.hasFreeKey || val > m.freeVal {
+			m.storeFree(val)
+		}
+		return
+	}
+
+	ptr, ok := m.slot(key)
+	switch {
+	case !ok:
+		m.insert(ptr, key, val)
+	case val > m.data[ptr+1]:
+		m.data[ptr+1] = val
+	}
+}
+
+// MergeMax merges the other map into this one, keeping the maximum of the values for
+// keys which are present in both maps.
+func (m *Map) MergeMax(other *Map) {
+	other.RangeEach(m.StoreMax)
+}
+
+// Merge merges the other map into this one. Keys which are only present in the other
+// map are inserted, and for keys present in both maps the value is set to the result
+// of combine, which is called with the current and the incoming values.
+func (m *Map) Merge(other *Map, combine func(a, b uint32) uint32) {
+	other.ForEach(func(key, incoming uint32) {
+		m.Update(key, func(current uint32, loaded bool) uint32 {
+			if !loaded {
+				return incoming
+			}
+			return combine(current, incoming)
+		})
+	})
+}
+
+// StoreGoMap stores every entry of a standard map into this one, overwriting the
+// values of keys which are already present. The map is grown once ahead of time to
+// fit the additional entries, which helps migrating code from map[uint32]uint32.
+func (m *Map) StoreGoMap(src map[uint32]uint32) {
+	capacity := arraySize(m.Count()+len(src), float64(m.fillFactor))
+	if capacity > m.Capacity() {
+		m.resize(capacity)
+	}
+
+	for key, val := range src {
+		m.Store(key, val)
+	}
+}
+
+// MergeSorted stores the keys with their values, which must have the same length and
+// be sorted by key so that repeated keys are adjacent. When a key is already present
+// or repeated, the optional resolve function is called with the current and incoming
+// values in input order, otherwise the incoming value wins. Each distinct key is only
+// probed once and the map is grown ahead of time to fit the additional entries.
+func (m *Map) MergeSorted(keys, vals []uint32, resolve func(key, a, b uint32) uint32) {
+	switch {
+	case len(keys) != len(vals):
+		panic("intmap: keys and values must have the same length")
+	case !slices.IsSorted(keys):
+		panic("intmap: keys must be sorted")
+	}
+
+	capacity := arraySize(m.Count()+len(keys), float64(m.fillFactor))
+	if capacity > m.Capacity() {
+		m.resize(capacity)
+	}
+
+	for i := 0; i < len(keys); {
+		key := keys[i]
+		end := i + 1
+		for end < len(keys) && keys[end] == key {
+			end++
+		}
+
+		// Locate the key once, then fold every incoming value into the current one
+		var ptr, val uint32
+		var ok bool
+		switch {
+		case key == isFree:
+			val, ok = m.freeVal, m.hasFreeKey
+		default:
+			if ptr, ok = m.slot(key); ok {
+				val = m.data[ptr+1]
+			}
+		}
+
+		for _, next := range vals[i:end] {
+			if ok && resolve != nil {
+				next = resolve(key, val, next)
 			}
+			val, ok = next, true
+		}
+
+		switch {
+		case key == isFree:
+			m.storeFree(val)
+		case m.data[ptr] == key:
+			m.data[ptr+1] = val
+		default:
+			m.insert(ptr, key, val)
+		}
+		i = end
+	}
+}
+
+// SwapOrStore sets the value for a key and returns the previous value, if any. The
+// existed result reports whether the key was present before the call, which can also
+// be used to detect duplicate keys while loading the map from an external source.
+func (m *Map) SwapOrStore(key, val uint32) (prev uint32, existed bool) {
+	if key == isFree {
+		if existed = m.hasFreeKey; existed {
+			prev = m.freeVal
+		}
+		m.storeFree(val)
+		return
+	}
+
+	ptr, ok := m.slot(key)
+	if !ok {
+		m.insert(ptr, key, val)
+		return 0, false
+	}
+
+	prev = m.data[ptr+1]
+	m.data[ptr+1] = val
+	return prev, true
+}
+
+// LoadAndReset returns the value for a key and resets it to zero, which is useful to
+// drain counters. Unlike deleting the key, it stays in place for the next interval.
+// The loaded result reports whether the key was present.
+func (m *Map) LoadAndReset(key uint32) (value uint32, loaded bool) {
+	if key == isFree {
+		if !m.hasFreeKey {
+			return 0, false
+		}
+
+		value, m.freeVal = m.freeVal, 0
+		return value, true
+	}
+
+	ptr, ok := m.slot(key)
+	if !ok {
+		return 0, false
+	}
+
+	value = m.data[ptr+1]
+	m.data[ptr+1] = 0
+	return value, true
+}
+
+// Swap sets the value for a key and returns the previous value, if any. The loaded
+// result reports whether the key was present. It is equivalent to SwapOrStore and
+// follows the naming of sync.Map.
+func (m *Map) Swap(key, val uint32) (previous uint32, loaded bool) {
+	return m.SwapOrStore(key, val)
+}
+
+// CompareAndSwap sets the value for a key to new only if it is present and its value
+// is equal to old, and returns whether the value was swapped. It never grows the map.
+func (m *Map) CompareAndSwap(key, old, new uint32) bool {
+	if key == isFree {
+		if !m.hasFreeKey || m.freeVal != old {
+			return false
+		}
+
+		m.freeVal = new
+		return true
+	}
+
+	ptr, ok := m.slot(key)
+	if !ok || m.data[ptr+1] != old {
+		return false
+	}
+
+	m.data[ptr+1] = new
+	return true
+}
+
+// Delete deletes the value for a key.
+func (m *Map) Delete(key uint32) {
+	if key == isFree {
+		m.deleteFree()
+		return
+	}
+
+	if ptr, ok := m.slot(key); ok {
+		m.shiftKeys(ptr, nil)
+		m.count--
+		m.shrink()
+	}
+}
+
+// LoadAndDelete deletes the value for a key and returns it. The loaded result reports
+// whether the key was present. The key is only probed once.
+func (m *Map) LoadAndDelete(key uint32) (value uint32, loaded bool) {
+	if key == isFree {
+		if !m.hasFreeKey {
+			return 0, false
+		}
+
+		value = m.freeVal
+		return value, m.deleteFree()
+	}
+
+	ptr, ok := m.slot(key)
+	if !ok {
+		return 0, false
+	}
+
+	value = m.data[ptr+1]
+	m.shiftKeys(ptr, nil)
+	m.count--
+	m.shrink()
+	return value, true
+}
+
+// CompareAndDelete deletes the value for a key only if its value is equal to old, and
+// returns whether it was deleted.
+func (m *Map) CompareAndDelete(key, old uint32) bool {
+	if key == isFree {
+		return m.hasFreeKey && m.freeVal == old && m.deleteFree()
+	}
+
+	ptr, ok := m.slot(key)
+	if !ok || m.data[ptr+1] != old {
+		return false
+	}
+
+	m.shiftKeys(ptr, nil)
+	m.count--
+	m.shrink()
+	return true
+}
+
+// DeleteObserve deletes the value for a key and returns whether it was present. Since
+// the map does not use tombstones, the entries following the deleted one are shifted
+// back to fill the gap, and onShift is called with the slots each entry moved from and
+// to, as well as its key.
+func (m *Map) DeleteObserve(key uint32, onShift func(from, to int, key uint32)) bool {
+	if key == isFree {
+		return m.deleteFree()
+	}
+
+	ptr, ok := m.slot(key)
+	if ok {
+		m.shiftKeys(ptr, onShift)
+		m.count--
+		m.shrink()
+	}
+	return ok
+}
+
+// ExtractIf removes every entry for which pred returns true and returns the number of
+// entries removed. The optional sink is called with each entry as it is removed, for
+// example to recycle its value. Since deleting shifts the entries which follow, the
+// matching entries are collected first and removed afterwards, so none are skipped.
+func (m *Map) ExtractIf(pred func(key, val uint32) bool, sink func(key, val uint32)) int {
+	var matched []uint64
+	m.RangeEach(func(key, val uint32) {
+		if pred(key, val) {
+			matched = append(matched, uint64(key)<<32|uint64(val))
+		}
+	})
+
+	for _, e := range matched {
+		key, val := uint32(e>>32), uint32(e)
+		if sink != nil {
+			sink(key, val)
 		}
+		m.Delete(key)
+	}
+	return len(matched)
+}
+
+// GrowHint grows the map ahead of time for the expected total number of entries. Unlike
+// sizing for the exact total, it over-allocates so that the map can keep growing past
+// the expected total without rehashing, which helps sustained ingestion. It never
+// shrinks the map.
+func (m *Map) GrowHint(expectedTotal int) {
+	if expectedTotal <= 0 {
+		return
+	}
+
+	capacity := min(2*arraySize(expectedTotal, float64(m.fillFactor)), maxCapacity)
+	if capacity > m.Capacity() {
+		m.resize(capacity)
+	}
+}
+
+// ReserveExact grows the map so that it can hold the total number of entries without
+// rehashing, and returns whether it was rehashed. Unlike GrowHint, it sizes the map
+// exactly for the total and does nothing if the capacity already suffices. Builders
+// which cache the capacity or the home buckets of keys must recompute them whenever
+// the map was rehashed.
+func (m *Map) ReserveExact(totalEntries int) (rehashed bool) {
+	capacity := arraySize(totalEntries, float64(m.fillFactor))
+	if capacity <= m.Capacity() {
+		return false
+	}
+
+	m.resize(capacity)
+	return true
+}
+
+// Maintain shrinks the map if it is mostly empty, typically after many deletions, and
+// returns the number of bytes freed. The map is shrunk once its load drops below a
+// quarter of the fill factor, to a capacity where the load is at most half of the
+// fill factor like with SetShrinkPolicy. Zero is returned if nothing was done.
+func (m *Map) Maintain() (freedBytes int) {
+	capacity := m.Capacity()
+	if size := arraySize(2*int(m.count), float64(m.fillFactor)); size < capacity {
+		m.resize(size)
+		return (capacity - size) * 8 // a key and a value per slot
+	}
+	return 0
+}
+
+// Shrink resizes the map down to the smallest capacity which fits its entries at the
+// fill factor, releasing the memory of a map which has grown larger than it needs to
+// be, for example after a purge. Unlike Maintain, no room is left for new entries, so
+// the next inserts may grow the map again. It does nothing if the map is not larger
+// than needed.
+func (m *Map) Shrink() {
+	if size := arraySize(int(m.count), float64(m.fillFactor)); size < m.Capacity() {
+		m.resize(size)
+	}
+}
+
+// SetFillFactor changes the fill factor of the map, which must be in (0, 1) and above
+// twice the load set by SetShrinkPolicy. Lowering the fill factor reduces the probe
+// lengths at the cost of memory and immediately grows the map if it already holds
+// more entries than the new fill factor allows. Raising it never shrinks the map, but
+// lets it hold more entries before growing.
+func (m *Map) SetFillFactor(fillFactor float64) {
+	switch {
+	case fillFactor <= 0 || fillFactor >= 1:
+		panic("intmap: fill factor must be in (0, 1)")
+	case float64(m.minLoad) >= fillFactor/2:
+		panic("intmap: fill factor must be above twice the shrink load")
 	}
+
+	m.fillFactor = float32(fillFactor)
+	if capacity := arraySize(int(m.count), fillFactor); capacity > m.Capacity() {
+		m.resize(capacity)
+		return
+	}
+
+	m.threshold = thresholdOf(m.Capacity(), fillFactor)
+}
+
+// SetShrinkPolicy enables the automatic shrinking of the map on Delete. Once the
+// number of entries drops below minLoad of the capacity, the map is resized down to
+// a capacity where the load is at most half of the fill factor, so that subsequent
+// inserts do not cause it to grow right back. The map never shrinks below the capacity
+// it was created with, so that a presized map is not shrunk before it is filled, and
+// enabling the policy does not resize the map by itself. A minLoad of zero disables
+// shrinking.
+func (m *Map) SetShrinkPolicy(minLoad float64) {
+	if minLoad < 0 || minLoad >= float64(m.fillFactor)/2 {
+		panic("intmap: shrink load must be in [0, fillFactor/2)")
+	}
+
+	m.minLoad = float32(minLoad)
+}
+
+// SetShrinkFactor is equivalent to SetShrinkPolicy, the map shrinks automatically
+// on Delete once the number of entries drops below factor of the capacity. The map
+// never shrinks below the capacity it was created with, nor below the capacity of
+// a map for a single entry at the current fill factor. A factor of zero, the default,
+// disables shrinking.
+func (m *Map) SetShrinkFactor(factor float64) {
+	m.SetShrinkPolicy(factor)
 }
 
 // Count returns number of key/value pairs in the map.
@@ -160,6 +802,45 @@ func (m *Map) Count() int {
 	return int(m.count)
 }
 
+// DistinctValues returns the number of unique values stored in the map.
+func (m *Map) DistinctValues() int {
+	seen := New(max(m.Count(), 1), .9)
+	m.RangeEach(func(_, value uint32) {
+		seen.Store(value, 0)
+	})
+	return seen.Count()
+}
+
+// SumValues returns the sum of all of the values stored in the map, widened so that
+// it does not wrap around.
+func (m *Map) SumValues() (sum uint64) {
+	m.ForEach(func(_, value uint32) {
+		sum += uint64(value)
+	})
+	return
+}
+
+// MaxValue returns the entry with the largest value, breaking ties by the smallest key
+// so that the result is deterministic. The ok result is false if the map is empty.
+func (m *Map) MaxValue() (key, val uint32, ok bool) {
+	m.ForEach(func(k, v uint32) {
+		if !ok || v > val || (v == val && k < key) {
+			key, val, ok = k, v, true
+		}
+	})
+	return
+}
+
+// AverageValue returns the mean of all of the values stored in the map, or zero if
+// the map is empty.
+func (m *Map) AverageValue() float64 {
+	if m.Count() == 0 {
+		return 0
+	}
+
+	return float64(m.SumValues()) / float64(m.Count())
+}
+
 // Range calls f sequentially for each key and value present in the map. If fn
 // returns false, range stops the iteration.
 func (m *Map) Range(fn func(key, value uint32) bool) {
@@ -167,28 +848,105 @@ func (m *Map) Range(fn func(key, value uint32) bool) {
 		return
 	}
 
+	for i := m.next(0); i < len(m.data); i = m.next(i + 2) {
+		if !fn(m.data[i], m.data[i+1]) {
+			return
+		}
+	}
+}
+
+// All returns an iterator over every key and value present in the map, in the same
+// order as Range, so that the map can be used in a for-range loop.
+func (m *Map) All() iter.Seq2[uint32, uint32] {
+	return m.Range
+}
+
+// Keys returns an iterator over every key present in the map, in the same order as
+// Range.
+func (m *Map) Keys() iter.Seq[uint32] {
+	return func(yield func(key uint32) bool) {
+		m.Range(func(key, _ uint32) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Values returns an iterator over every value present in the map, in the same order
+// as Range.
+func (m *Map) Values() iter.Seq[uint32] {
+	return func(yield func(value uint32) bool) {
+		m.Range(func(_, value uint32) bool {
+			return yield(value)
+		})
+	}
+}
+
+// KeySlice returns a slice with every key present in the map, in the same order as
+// Range. The slice is allocated with exactly as many elements as there are entries.
+func (m *Map) KeySlice() []uint32 {
+	out := make([]uint32, 0, m.Count())
+	m.ForEach(func(key, _ uint32) {
+		out = append(out, key)
+	})
+	return out
+}
+
+// ValueSlice returns a slice with every value present in the map, in the same order
+// as Range. The slice is allocated with exactly as many elements as there are entries.
+func (m *Map) ValueSlice() []uint32 {
+	out := make([]uint32, 0, m.Count())
+	m.ForEach(func(_, value uint32) {
+		out = append(out, value)
+	})
+	return out
+}
+
+// RangeValue calls fn sequentially for each key which has the given value. If fn
+// returns false, the iteration stops.
+func (m *Map) RangeValue(val uint32, fn func(key uint32) bool) {
+	if m.hasFreeKey && m.freeVal == val && !fn(isFree) {
+		return
+	}
+
 	for i := 0; i < len(m.data); i += 2 {
-		if k := m.data[i]; k != isFree {
-			if !fn(k, m.data[i+1]) {
+		if k := m.data[i]; k != isFree && m.data[i+1] == val {
+			if !fn(k) {
 				return
 			}
 		}
 	}
 }
 
-// RangeEach calls f sequentially for each key and value present in the map.
-func (m *Map) RangeEach(fn func(key, value uint32)) {
+// RangeKeys calls fn sequentially for each key in the range [lo, hi] and its value.
+// Since the map is unordered, the whole backing array is scanned and the keys are not
+// visited in order. If fn returns false, the iteration stops.
+func (m *Map) RangeKeys(lo, hi uint32, fn func(key, value uint32) bool) {
+	m.Range(func(key, value uint32) bool {
+		if key < lo || key > hi {
+			return true
+		}
+		return fn(key, value)
+	})
+}
+
+// ForEach calls fn sequentially for each key and value present in the map. This is
+// the fastest way to visit every entry, since it is not possible to stop early.
+func (m *Map) ForEach(fn func(key, value uint32)) {
 	if m.hasFreeKey {
 		fn(isFree, m.freeVal)
 	}
 
-	for i := 0; i < len(m.data); i += 2 {
-		if k := m.data[i]; k != isFree {
-			fn(k, m.data[i+1])
-		}
+	for i := m.next(0); i < len(m.data); i = m.next(i + 2) {
+		fn(m.data[i], m.data[i+1])
 	}
 }
 
+// RangeEach calls f sequentially for each key and value present in the map. It is
+// equivalent to ForEach.
+func (m *Map) RangeEach(fn func(key, value uint32)) {
+	m.ForEach(fn)
+}
+
 // RangeErr calls f sequentially for each key and value present in the map. If fn
 // returns error, range stops the iteration.
 func (m *Map) RangeErr(fn func(key, value uint32) error) error {
@@ -198,92 +956,481 @@ func (m *Map) RangeErr(fn func(key, value uint32) error) error {
 		}
 	}
 
-	for i := 0; i < len(m.data); i += 2 {
-		if k := m.data[i]; k != isFree {
-			if err := fn(k, m.data[i+1]); err != nil {
-				return err
-			}
+	for i := m.next(0); i < len(m.data); i = m.next(i + 2) {
+		if err := fn(m.data[i], m.data[i+1]); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// RangeBatch calls fn with batches of up to batchSize keys and their values, until all
+// of the entries have been visited or fn returns false. The slices are reused between
+// calls and are only valid until fn returns.
+func (m *Map) RangeBatch(batchSize int, fn func(keys, vals []uint32) bool) {
+	if batchSize <= 0 {
+		panic("intmap: batch size must be positive")
+	}
+
+	size := min(batchSize, m.Count())
+	keys, vals := make([]uint32, 0, size), make([]uint32, 0, size)
+	if m.hasFreeKey {
+		keys = append(keys, isFree)
+		vals = append(vals, m.freeVal)
+	}
+
+	for i := m.next(0); i < len(m.data); i = m.next(i + 2) {
+		if len(keys) == batchSize {
+			if !fn(keys, vals) {
+				return
+			}
+			keys, vals = keys[:0], vals[:0]
+		}
+
+		keys = append(keys, m.data[i])
+		vals = append(vals, m.data[i+1])
+	}
+
+	if len(keys) > 0 {
+		fn(keys, vals)
+	}
+}
+
+// Entry represents a key and its value.
+type Entry struct {
+	Key   uint32
+	Value uint32
+}
+
+// AppendSortedEntries appends every entry of the map to dst in ascending key order,
+// so the zero key comes first, and returns the extended slice. The entries are sorted
+// in place within dst, so no allocation happens if it has enough capacity.
+func (m *Map) AppendSortedEntries(dst []Entry) []Entry {
+	dst = slices.Grow(dst, m.Count())
+	start := len(dst)
+	m.ForEach(func(key, value uint32) {
+		dst = append(dst, Entry{Key: key, Value: value})
+	})
+
+	slices.SortFunc(dst[start:], func(a, b Entry) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+	return dst
+}
+
+// ErrSkip can be returned by the function passed to RangeErrSkip to skip an entry and
+// continue the iteration.
+var ErrSkip = errors.New("intmap: skip entry")
+
+// RangeErrSkip calls f sequentially for each key and value present in the map. If fn
+// returns ErrSkip, or an error wrapping it, the entry is skipped and the iteration
+// continues. If fn returns any other error, range stops the iteration and returns it.
+func (m *Map) RangeErrSkip(fn func(key, value uint32) error) error {
+	return m.RangeErr(func(key, value uint32) error {
+		if err := fn(key, value); err != nil && !errors.Is(err, ErrSkip) {
+			return err
+		}
+		return nil
+	})
+}
+
+// next returns the position of the first occupied slot at or after the position i in
+// the backing array, or a position past its end if there is none. This is the shared
+// cursor of every iteration, which skips empty blocks using the presence index.
+func (m *Map) next(i int) int {
+	for ; i < len(m.data); i += 2 {
+		if m.data[i] != isFree {
+			return i
+		}
+
+		if m.index != nil && i&127 == 0 && m.index[i>>7] == 0 {
+			i += 126 // skip a block of 64 empty slots
+		}
+	}
+	return i
+}
+
 // Clone returns a copy of the map.
 func (m *Map) Clone() *Map {
-	clone := New(len(m.data)/2, float64(m.fillFactor))
-	clone.count = m.count
-	clone.mask[0] = m.mask[0]
-	clone.mask[1] = m.mask[1]
-	clone.hasFreeKey = m.hasFreeKey
-	clone.freeVal = m.freeVal
+	clone := *m
+	clone.data = alloc(len(m.data))
 	copy(clone.data, m.data)
-	return clone
+	if m.index != nil {
+		clone.index = slices.Clone(m.index)
+	}
+	return &clone
+}
+
+// SharesBackingWith returns whether both maps use the same backing array, which is
+// never the case for a map and its Clone. This is meant to be used in tests.
+func (m *Map) SharesBackingWith(other *Map) bool {
+	return unsafe.SliceData(m.data) == unsafe.SliceData(other.data)
+}
+
+// Checksum returns a hash of the contents of the map which does not depend on the
+// order of the entries, so that two maps holding the same keys and values have the
+// same checksum regardless of their capacity, options or history. It is computed by
+// adding up a well-mixed hash of every key/value pair.
+func (m *Map) Checksum() (sum uint64) {
+	m.ForEach(func(key, value uint32) {
+		h := uint64(key)<<32 | uint64(value) + 0x9e3779b97f4a7c15
+		h = (h ^ h>>30) * 0xbf58476d1ce4e5b9
+		h = (h ^ h>>27) * 0x94d049bb133111eb
+		sum += h ^ h>>31
+	})
+	return
+}
+
+// Equal returns whether both maps contain the same keys with the same values. The
+// capacity, options and arrangement of the entries do not affect the result.
+func (m *Map) Equal(other *Map) bool {
+	if m.Count() != other.Count() {
+		return false
+	}
+
+	equal := true
+	m.Range(func(key, value uint32) bool {
+		v, ok := other.Load(key)
+		equal = ok && v == value
+		return equal
+	})
+	return equal
+}
+
+// Jaccard returns the Jaccard similarity of the key sets of both maps, which is the
+// size of their intersection divided by the size of their union. Values are ignored
+// and two empty maps are considered identical.
+func (m *Map) Jaccard(other *Map) float64 {
+	small, large := m, other
+	if small.Count() > large.Count() {
+		small, large = large, small
+	}
+
+	intersection := 0
+	small.RangeEach(func(key, _ uint32) {
+		if _, ok := large.Load(key); ok {
+			intersection++
+		}
+	})
+
+	union := m.Count() + other.Count() - intersection
+	if union == 0 {
+		return 1
+	}
+
+	return float64(intersection) / float64(union)
+}
+
+// TakeAll moves the contents of the map into a new map which is returned, leaving
+// the receiver empty with the same capacity. This is useful to collect and reset a
+// set of counters in a single step.
+func (m *Map) TakeAll() *Map {
+	out := *m
+	m.generation++
+	m.data = alloc(len(m.data))
+	if m.index != nil {
+		m.index = make([]uint64, len(m.index))
+	}
+
+	m.count = 0
+	m.hasFreeKey = false
+	m.freeVal = 0
+	return &out
+}
+
+// ResetValues sets the value of every key to zero in a single pass, keeping the keys
+// in place. This is useful to reset a set of counters at every interval.
+func (m *Map) ResetValues() {
+	m.freeVal = 0
+	for i := m.next(0); i < len(m.data); i = m.next(i + 2) {
+		m.data[i+1] = 0
+	}
+}
+
+// TakeValues returns the keys and values of the map as a standard map and sets every
+// value to zero, keeping the keys in place. This collects and resets a set of counters
+// in a single step.
+func (m *Map) TakeValues() map[uint32]uint32 {
+	out := make(map[uint32]uint32, m.Count())
+	m.ForEach(func(key, value uint32) {
+		out[key] = value
+	})
+
+	m.ResetValues()
+	return out
+}
+
+// Rekey returns a new map where every key is transformed by fn. If several keys are
+// transformed into the same key, the optional resolve function is called with the
+// already stored and the incoming values, otherwise the last visited value wins.
+func (m *Map) Rekey(fn func(key uint32) uint32, resolve func(key, prev, next uint32) uint32) *Map {
+	out := New(max(m.Count(), 1), float64(m.fillFactor))
+	m.RangeEach(func(key, value uint32) {
+		key = fn(key)
+		if resolve != nil {
+			if prev, ok := out.Load(key); ok {
+				value = resolve(key, prev, value)
+			}
+		}
+
+		out.Store(key, value)
+	})
+	return out
+}
+
+// TrimToTopN keeps only the n entries with the largest values and removes the rest,
+// breaking ties by the smallest key so that the result is deterministic. The map keeps
+// its capacity and is rebuilt from the retained entries.
+func (m *Map) TrimToTopN(n int) {
+	if n >= m.Count() {
+		return
+	}
+
+	// Sort by descending value, then by ascending key
+	order := make([]uint64, 0, m.Count())
+	m.ForEach(func(key, value uint32) {
+		order = append(order, uint64(value)<<32|uint64(^key))
+	})
+	slices.Sort(order)
+
+	m.Clear()
+	for _, e := range order[len(order)-max(n, 0):] {
+		m.Store(^uint32(e), uint32(e>>32))
+	}
+}
+
+// SwapContents exchanges the contents of both maps in constant time, without copying
+// or allocating, which is useful for double buffering. Since the layout of the entries
+// depends on them, the settings of the maps such as the fill factor and the options
+// are exchanged as well. This is not safe for concurrent use, even through Sync.
+func (m *Map) SwapContents(other *Map) {
+	*m, *other = *other, *m
+	m.generation++
+	other.generation++
 }
 
 // Clear removes all entries from the map.
 func (m *Map) Clear() {
+	m.generation++
 	clear(m.data)
+	clear(m.index)
 	m.count = 0
 	m.hasFreeKey = false
 	m.freeVal = 0
 }
 
-// shiftKeys shifts entries with the same hash.
-func (m *Map) shiftKeys(pos uint32) {
+// storeFree sets the value of the 'free' key, which is kept outside of the array.
+func (m *Map) storeFree(val uint32) {
+	if !m.hasFreeKey {
+		m.hasFreeKey = true
+		m.count++
+	}
+	m.freeVal = val
+}
+
+// deleteFree deletes the 'free' key and returns whether it was present.
+func (m *Map) deleteFree() bool {
+	if !m.hasFreeKey {
+		return false
+	}
+
+	m.hasFreeKey = false
+	if m.zeroValues {
+		m.freeVal = 0
+	}
+
+	m.count--
+	m.shrink()
+	return true
+}
+
+// slot returns the position of the key in the backing array if it is present, or
+// the position of the free slot where it should be inserted otherwise. The key must
+// not be the 'free' key.
+func (m *Map) slot(key uint32) (ptr uint32, ok bool) {
+	if ptr = bucketOf(key, m.mask[0]); m.hasher != nil {
+		ptr = m.bucketWith(key)
+	}
+
+	for ; ; ptr = (ptr + m.step) & m.mask[1] {
+		switch m.data[ptr] {
+		case isFree:
+			return ptr, false
+		case key:
+			return ptr, true
+		}
+	}
+}
+
+// insert writes a new key/value pair at the free slot previously returned by slot,
+// and grows the map if the threshold was reached.
+func (m *Map) insert(ptr, key, val uint32) {
+	m.data[ptr] = key
+	m.data[ptr+1] = val
+	if m.index != nil {
+		m.index[ptr>>7] |= 1 << (ptr >> 1 & 63)
+	}
+
+	if m.count >= m.threshold {
+		expect := m.count + 1 // the entry was placed, but is not counted yet
+		m.rehash()
+		m.assertCount(expect)
+	} else {
+		m.count++
+	}
+}
+
+// shiftKeys shifts entries with the same hash. If provided, onShift is called for
+// every entry which is moved.
+func (m *Map) shiftKeys(pos uint32, onShift func(from, to int, key uint32)) {
 	var last, slot uint32
 	var k uint32
 	var data = m.data
 	for {
 		last = pos
-		pos = (last + 2) & m.mask[1]
+		pos = (last + m.step) & m.mask[1]
 		for {
 			k = data[pos]
 			if k == isFree {
 				data[last] = isFree
+				if m.zeroValues {
+					data[last+1] = 0
+				}
+				if m.index != nil {
+					m.index[last>>7] &^= 1 << (last >> 1 & 63)
+				}
 				return
 			}
 
-			slot = bucketOf(k, m.mask[0])
-			if last <= pos {
-				if last >= slot || slot > pos {
-					break
-				}
-			} else {
-				if last >= slot && slot > pos {
-					break
-				}
+			if slot = bucketOf(k, m.mask[0]); m.hasher != nil {
+				slot = m.bucketWith(k)
+			}
+			// The entry can be moved into the gap if the gap comes before its
+			// current position in the probe sequence starting at its home bucket.
+			if m.distance(last, slot) < m.distance(pos, slot) {
+				break
 			}
-			pos = (pos + 2) & m.mask[1]
+			pos = (pos + m.step) & m.mask[1]
 		}
 		data[last] = k
 		data[last+1] = data[pos+1]
+		if onShift != nil {
+			onShift(int(pos>>1), int(last>>1), k)
+		}
 	}
 }
 
-// rehash rehashes the key space and resizes the map
+// distance returns the number of probes from the home bucket to the position.
+func (m *Map) distance(ptr, home uint32) uint32 {
+	return (((ptr - home) & m.mask[1]) >> 1 * m.inverse) & m.mask[0]
+}
+
+// rehash rehashes the key space and doubles the size of the map
 func (m *Map) rehash() {
-	newCapacity := len(m.data) * 2
-	m.threshold = int32(math.Floor(float64(newCapacity/2) * float64(m.fillFactor)))
-	m.mask = [2]uint32{uint32(newCapacity/2 - 1), uint32(newCapacity - 1)}
+	m.resize(len(m.data))
+}
+
+// shrink resizes the map down if the shrink policy is enabled and the load of the
+// map has dropped below the configured minimum.
+func (m *Map) shrink() {
+	capacity := len(m.data) / 2
+	if m.minLoad == 0 || float32(m.count) >= m.minLoad*float32(capacity) {
+		return
+	}
+
+	fill := float64(m.fillFactor)
+	size := max(arraySize(2*int(m.count), fill), arraySize(1, fill), int(m.minSize))
+	if size < capacity {
+		m.resize(size)
+	}
+}
 
-	// copy of original data
-	data := make([]uint32, len(m.data))
-	copy(data, m.data)
+// resize rehashes the key space into a backing array of the given capacity, which
+// must be a power of two large enough to hold all of the entries.
+func (m *Map) resize(capacity int) {
+	m.generation++
+	m.threshold = thresholdOf(capacity, float64(m.fillFactor))
+	m.mask = [2]uint32{uint32(capacity - 1), uint32(2*capacity - 1)}
+
+	// swap the backing array, the old one is recycled once reinserted
+	data := m.data
+	m.data = alloc(2 * capacity)
+	if m.index != nil {
+		m.index = make([]uint64, indexSize(capacity))
+	}
 
-	m.data = make([]uint32, newCapacity)
 	if m.hasFreeKey { // reset size
 		m.count = 1
 	} else {
 		m.count = 0
 	}
 
-	var o uint32
+	expect := m.count
 	for i := 0; i < len(data); i += 2 {
-		o = data[i]
-		if o != isFree {
-			m.Store(o, data[i+1])
+		if key := data[i]; key != isFree {
+			ptr, _ := m.slot(key)
+			m.insert(ptr, key, data[i+1])
+			expect++
 		}
 	}
+
+	m.assertCount(expect)
+
+	if m.zeroValues {
+		clear(data)
+	}
+	release(data)
+}
+
+// buffers pools the backing arrays by their power-of-two size, so that maps
+// which repeatedly grow do not allocate a fresh array on every rehash.
+var buffers [64]sync.Pool
+
+// alloc returns a zeroed backing array of the requested power-of-two size.
+func alloc(size int) []uint32 {
+	if ptr, ok := buffers[bits.TrailingZeros(uint(size))].Get().(*uint32); ok {
+		data := unsafe.Slice(ptr, size)
+		clear(data)
+		return data
+	}
+	return make([]uint32, size)
+}
+
+// onRelease, when set, is called with every backing array right before it is
+// returned to the pool. It lets the tests inspect an array while it is still owned.
+var onRelease func(data []uint32)
+
+// release returns a backing array which is no longer referenced to the pool. Only
+// the pointer is pooled, which avoids allocating a slice header on every call.
+func release(data []uint32) {
+	if onRelease != nil {
+		onRelease(data)
+	}
+	buffers[bits.TrailingZeros(uint(len(data)))].Put(unsafe.SliceData(data))
+}
+
+// CapacityFor returns the capacity of a map created with the given size and fill
+// factor, which are validated like in New. Each slot takes 8 bytes of memory.
+func CapacityFor(size int, fillFactor float64) int {
+	if fillFactor <= 0 || fillFactor >= 1 {
+		panic("intmap: fill factor must be in (0, 1)")
+	}
+	if size <= 0 {
+		panic("intmap: size must be positive")
+	}
+
+	return arraySize(size, fillFactor)
+}
+
+// HomeBucket returns the bucket in which the map first attempts to place a key, given
+// the capacity of the map which must be a power of two. The result is in the range
+// [0, capacity) and matches the placement used by the map internally.
+func HomeBucket(key uint32, capacity int) int {
+	if capacity <= 0 || capacity&(capacity-1) != 0 {
+		panic("intmap: capacity must be a power of two")
+	}
+
+	return int(bucketOf(key, uint32(capacity-1)) >> 1)
 }
 
 // bucketOf calcultes the hash bucket for the integer key
@@ -292,20 +1439,22 @@ func bucketOf(key, mask uint32) uint32 {
 	return (h & mask) << 1
 }
 
+// thresholdOf returns the number of entries above which a map of the capacity grows,
+// which is at least one so that a very low fill factor does not grow on every insert.
+func thresholdOf(capacity int, fill float64) int32 {
+	return max(1, int32(math.Floor(float64(capacity)*fill)))
+}
+
+// arraySize returns the power-of-two capacity required to hold the number of entries
+// at the given fill factor, bounded by the maximum capacity.
 func arraySize(size int, fill float64) int {
-	x := uint32(math.Ceil(float64(size) / fill))
+	x := math.Ceil(float64(size) / fill)
 	switch {
-	case x >= math.MaxUint32:
-		return math.MaxUint32
+	case x >= maxCapacity:
+		return maxCapacity
 	case x < 8:
 		return 8
 	}
 
-	x--
-	x |= x >> 1
-	x |= x >> 2
-	x |= x >> 4
-	x |= x >> 8
-	x |= x >> 16
-	return int(x + 1)
+	return 1 << bits.Len32(uint32(x)-1)
 }
@@ -4,9 +4,14 @@
 package intmap
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"hash/crc32"
+	"math"
 	"math/rand/v2"
+	"runtime"
+	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -289,6 +294,45 @@ func TestRangeSequential(t *testing.T) {
 	}
 }
 
+/*
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkRangeSequential/Range-8         	     500	   2490250 ns/op	       0 B/op	       0 allocs/op
+BenchmarkRangeSequential/ForEach-8       	     500	   2388879 ns/op	       0 B/op	       0 allocs/op
+BenchmarkRangeSequential/RangeErr-8      	     500	   2539951 ns/op	       0 B/op	       0 allocs/op
+*/
+func BenchmarkRangeSequential(b *testing.B) {
+	m := sequentialMap(1000000)
+	sum := uint32(0)
+	b.Run("Range", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.Range(func(key, value uint32) bool {
+				sum += value
+				return true
+			})
+		}
+	})
+
+	b.Run("ForEach", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.ForEach(func(key, value uint32) {
+				sum += value
+			})
+		}
+	})
+
+	b.Run("RangeErr", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.RangeErr(func(key, value uint32) error {
+				sum += value
+				return nil
+			})
+		}
+	})
+}
+
 func TestRangeRandom(t *testing.T) {
 	for _, size := range []int{100, 10000, 1000000} {
 		count := 0
@@ -389,7 +433,9 @@ func TestMapClone(t *testing.T) {
 	clone := original.Clone()
 
 	// Check that the clone is not the same object as the original
-	assert.NotEqual(t, clone, original, "clone and original are the same object")
+	assert.NotSame(t, clone, original, "clone and original are the same object")
+	assert.False(t, clone.SharesBackingWith(original), "clone and original share the array")
+	assert.True(t, original.SharesBackingWith(original))
 
 	// Check that the clone has the same count
 	assert.Equal(t, original.Count(), clone.Count(), "clone count does not match original count")
@@ -409,6 +455,16 @@ func TestMapClone(t *testing.T) {
 	assert.False(t, ok, "modifying clone modified the original")
 }
 
+func TestMapCloneGrow(t *testing.T) {
+	clone := New(8, .99).Clone()
+	for i := uint32(0); i < 100; i++ {
+		clone.Store(i, i)
+	}
+
+	assert.Equal(t, 100, clone.Count())
+	assert.Equal(t, 128, clone.Capacity())
+}
+
 func TestRangeEach(t *testing.T) {
 	m := New(10, 0.6)
 	m.Store(isFree, 10)
@@ -495,3 +551,1618 @@ func TestRangeStop(t *testing.T) {
 	assert.Len(t, keys, 1)
 	assert.Len(t, values, 1)
 }
+
+/*
+cpu: Intel(R) Xeon(R) Processor
+before, without pooling the backing arrays:
+BenchmarkGrow/grow          	    2000	    161915 ns/op	  262144 B/op	      12 allocs/op
+BenchmarkGrow/grow-shrink   	    2000	    462673 ns/op	  305536 B/op	      15 allocs/op
+
+after:
+BenchmarkGrow/grow          	    2000	    229394 ns/op	  131272 B/op	       2 allocs/op
+BenchmarkGrow/grow-shrink   	    2000	    368462 ns/op	       0 B/op	       0 allocs/op
+*/
+func BenchmarkGrow(b *testing.B) {
+	b.Run("grow", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m := New(8, .90)
+			for k := uint32(0); k < 10000; k++ {
+				m.Store(k, k)
+			}
+		}
+	})
+
+	b.Run("grow-shrink", func(b *testing.B) {
+		m := New(8, .90)
+		m.SetShrinkPolicy(.1)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for k := uint32(0); k < 10000; k++ {
+				m.Store(k, k)
+			}
+			for k := uint32(0); k < 10000; k++ {
+				m.Delete(k)
+			}
+		}
+	})
+}
+
+func TestRekey(t *testing.T) {
+	m := sequentialMap(100)
+	out := m.Rekey(func(key uint32) uint32 {
+		return key + 1000
+	}, nil)
+
+	assert.Equal(t, 100, out.Count())
+	assert.Equal(t, 100, m.Count())
+	for i := uint32(0); i < 100; i++ {
+		v, ok := out.Load(i + 1000)
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+}
+
+func TestRekeyCollision(t *testing.T) {
+	m := sequentialMap(100)
+	out := m.Rekey(func(key uint32) uint32 {
+		return key % 10
+	}, func(key, prev, next uint32) uint32 {
+		return prev + next
+	})
+
+	assert.Equal(t, 10, out.Count())
+	for i := uint32(0); i < 10; i++ {
+		v, ok := out.Load(i)
+		assert.True(t, ok)
+		assert.Equal(t, 10*i+450, v)
+	}
+}
+
+func TestHomeBucket(t *testing.T) {
+	for _, capacity := range []int{8, 1024, 1 << 20} {
+		for i := 0; i < 1000; i++ {
+			key := rand.Uint32()
+			assert.Equal(t, int(bucketOf(key, uint32(capacity-1))/2), HomeBucket(key, capacity))
+			assert.Less(t, HomeBucket(key, capacity), capacity)
+		}
+	}
+
+	assert.Panics(t, func() {
+		HomeBucket(1, 10)
+	})
+	assert.Panics(t, func() {
+		HomeBucket(1, 0)
+	})
+}
+
+func TestSwapOrStore(t *testing.T) {
+	m := New(4, .9)
+	for i := uint32(0); i < 100; i++ {
+		prev, existed := m.SwapOrStore(i, i)
+		assert.False(t, existed)
+		assert.Equal(t, uint32(0), prev)
+	}
+
+	assert.Equal(t, 100, m.Count())
+	for i := uint32(0); i < 100; i++ {
+		prev, existed := m.SwapOrStore(i, i*2)
+		assert.True(t, existed)
+		assert.Equal(t, i, prev)
+	}
+
+	assert.Equal(t, 100, m.Count())
+	for i := uint32(0); i < 100; i++ {
+		v, ok := m.Load(i)
+		assert.True(t, ok)
+		assert.Equal(t, i*2, v)
+	}
+}
+
+func TestShrinkPolicy(t *testing.T) {
+	m := New(1, .99)
+	for i := uint32(0); i < 10000; i++ {
+		m.Store(i, i)
+	}
+
+	m.SetShrinkPolicy(.1)
+	assert.Equal(t, 16384, m.Capacity())
+
+	for i := uint32(0); i < 9900; i++ {
+		m.Delete(i)
+	}
+
+	assert.Equal(t, 100, m.Count())
+	assert.Equal(t, 256, m.Capacity())
+	for i := uint32(9900); i < 10000; i++ {
+		v, ok := m.Load(i)
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+
+	// Delete the rest, including the free key
+	m.Store(0, 1)
+	for i := uint32(9900); i < 10000; i++ {
+		m.Delete(i)
+	}
+	m.Delete(0)
+	assert.Equal(t, 0, m.Count())
+	assert.Equal(t, 8, m.Capacity())
+}
+
+func TestShrinkPolicyPresized(t *testing.T) {
+	m := New(100000, .9)
+	capacity := m.Capacity()
+	m.SetShrinkPolicy(.1)
+	assert.Equal(t, capacity, m.Capacity())
+
+	// Deleting from a presized map does not shrink it below its initial capacity
+	m.Store(1, 1)
+	m.Delete(1)
+	assert.Equal(t, capacity, m.Capacity())
+
+	for i := uint32(0); i < 100000; i++ {
+		assert.False(t, m.StoreReport(i, i))
+	}
+
+	for i := uint32(0); i < 100000; i++ {
+		m.Delete(i)
+	}
+	assert.Equal(t, capacity, m.Capacity())
+}
+
+func TestShrinkPolicyDisabled(t *testing.T) {
+	m := sequentialMap(1000)
+	capacity := m.Capacity()
+	for i := uint32(0); i < 1000; i++ {
+		m.Delete(i)
+	}
+
+	assert.Equal(t, capacity, m.Capacity())
+	assert.Panics(t, func() {
+		m.SetShrinkPolicy(.5)
+	})
+	assert.Panics(t, func() {
+		m.SetShrinkPolicy(-1)
+	})
+}
+
+func TestSetShrinkFactor(t *testing.T) {
+	m := New(8, .9)
+	m.SetShrinkFactor(.2)
+	capacity := m.Capacity()
+	for round := 0; round < 3; round++ {
+		for i := uint32(0); i < 5000; i++ {
+			m.Store(i, i)
+		}
+		for i := uint32(0); i < 5000; i++ {
+			m.Delete(i)
+		}
+
+		assert.Zero(t, m.Count())
+		assert.Equal(t, capacity, m.Capacity())
+	}
+
+	// Alternating around the minimum capacity does not resize back and forth
+	generation := m.generation
+	for i := 0; i < 100; i++ {
+		m.Store(1, 1)
+		m.Delete(1)
+	}
+	assert.Equal(t, generation, m.generation)
+}
+
+func TestSetShrinkFactorFloor(t *testing.T) {
+	m := New(1, .9)
+	m.SetFillFactor(.05)
+	m.SetShrinkFactor(.01)
+	assert.Equal(t, 1, m.Threshold())
+
+	for i := uint32(0); i < 1000; i++ {
+		m.Store(i, i)
+	}
+	for i := uint32(0); i < 1000; i++ {
+		m.Delete(i)
+	}
+
+	// The map does not shrink below the capacity for a single entry
+	assert.Equal(t, arraySize(1, .05), m.Capacity())
+	assert.Equal(t, 1, m.Threshold())
+	assert.False(t, m.StoreReport(1, 1))
+	assert.NoError(t, m.Validate())
+}
+
+func TestSumValues(t *testing.T) {
+	m := New(10, .9)
+	assert.Zero(t, m.SumValues())
+	assert.Zero(t, m.AverageValue())
+
+	m.Store(0, math.MaxUint32)
+	m.Store(1, math.MaxUint32)
+	m.Store(2, 1)
+	assert.Equal(t, 2*uint64(math.MaxUint32)+1, m.SumValues())
+	assert.Equal(t, float64(2*uint64(math.MaxUint32)+1)/3, m.AverageValue())
+}
+
+func TestMaxValue(t *testing.T) {
+	m := New(10, .9)
+	_, _, ok := m.MaxValue()
+	assert.False(t, ok)
+
+	for i := uint32(1); i < 1000; i++ {
+		m.Store(i, i%100)
+	}
+
+	key, val, ok := m.MaxValue()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(99), key)
+	assert.Equal(t, uint32(99), val)
+
+	// The 'free' key wins ties since it is the smallest
+	m.Store(0, 99)
+	key, val, _ = m.MaxValue()
+	assert.Equal(t, uint32(0), key)
+	assert.Equal(t, uint32(99), val)
+}
+
+func TestDistinctValues(t *testing.T) {
+	m := New(10, .9)
+	assert.Equal(t, 0, m.DistinctValues())
+
+	for i := uint32(0); i < 1000; i++ {
+		m.Store(i, i%7)
+	}
+	assert.Equal(t, 7, m.DistinctValues())
+
+	m.Store(5000, 0)
+	m.Store(5001, 100)
+	assert.Equal(t, 8, m.DistinctValues())
+}
+
+func TestTakeAll(t *testing.T) {
+	m := sequentialMap(100)
+	capacity := m.Capacity()
+	out := m.TakeAll()
+
+	assert.Equal(t, 0, m.Count())
+	assert.Equal(t, capacity, m.Capacity())
+	assert.Equal(t, 100, out.Count())
+	for i := uint32(0); i < 100; i++ {
+		_, ok := m.Load(i)
+		assert.False(t, ok)
+
+		v, ok := out.Load(i)
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+
+	// Both maps are independent
+	m.Store(1, 100)
+	v, _ := out.Load(1)
+	assert.Equal(t, uint32(1), v)
+}
+
+/*
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkFromSorted/random-8         	      39	  29467390 ns/op	16777381 B/op	       3 allocs/op
+BenchmarkFromSorted/sorted-8         	      96	  12673716 ns/op	16777381 B/op	       3 allocs/op
+*/
+func BenchmarkFromSorted(b *testing.B) {
+	const count = 1000000
+	keys, vals := make([]uint32, count), make([]uint32, count)
+	for i := range keys {
+		keys[i] = rand.Uint32()
+		vals[i] = uint32(i)
+	}
+
+	b.Run("random", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			FromSorted(keys, vals, .9)
+		}
+	})
+
+	capacity := arraySize(count, .9)
+	slices.SortFunc(keys, func(a, b uint32) int {
+		return HomeBucket(a, capacity) - HomeBucket(b, capacity)
+	})
+
+	b.Run("sorted", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			FromSorted(keys, vals, .9)
+		}
+	})
+}
+
+func TestFromSorted(t *testing.T) {
+	m := FromSorted([]uint32{0, 1, 2, 1}, []uint32{10, 20, 30, 40}, .9)
+	assert.Equal(t, 3, m.Count())
+
+	for k, v := range map[uint32]uint32{0: 10, 1: 40, 2: 30} {
+		out, ok := m.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, out)
+	}
+
+	assert.Equal(t, 0, FromSorted(nil, nil, .9).Count())
+	assert.Panics(t, func() {
+		FromSorted([]uint32{1}, nil, .9)
+	})
+}
+
+func TestStoreBounded(t *testing.T) {
+	m := New(10, .6)
+	capacity := m.Capacity()
+
+	stored := 0
+	for i := uint32(1); i < 100; i++ {
+		if m.StoreBounded(i, i) {
+			stored++
+		}
+	}
+
+	assert.Equal(t, capacity, m.Capacity())
+	assert.Equal(t, int(m.threshold), stored)
+	assert.Equal(t, stored, m.Count())
+
+	// Overwrites and the free key are still accepted
+	assert.True(t, m.StoreBounded(1, 100))
+	assert.True(t, m.StoreBounded(0, 100))
+	assert.False(t, m.StoreBounded(1000, 100))
+
+	v, _ := m.Load(1)
+	assert.Equal(t, uint32(100), v)
+	assert.NoError(t, m.Validate())
+}
+
+func TestStoreMax(t *testing.T) {
+	m := New(10, .9)
+	m.StoreMax(0, 5)
+	m.StoreMax(0, 3)
+	m.StoreMax(1, 5)
+	m.StoreMax(1, 3)
+	m.StoreMax(1, 7)
+	m.StoreMax(2, 0)
+
+	for k, v := range map[uint32]uint32{0: 5, 1: 7, 2: 0} {
+		out, ok := m.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, out)
+	}
+}
+
+func TestMergeMax(t *testing.T) {
+	a, b := New(10, .9), New(10, .9)
+	for i := uint32(0); i < 100; i++ {
+		a.Store(i, i)
+		b.Store(i+50, 100-i)
+	}
+
+	a.MergeMax(b)
+	assert.Equal(t, 150, a.Count())
+	for i := uint32(0); i < 150; i++ {
+		expect := uint32(0)
+		if i < 100 {
+			expect = i
+		}
+		if i >= 50 && 150-i > expect {
+			expect = 150 - i
+		}
+
+		v, ok := a.Load(i)
+		assert.True(t, ok)
+		assert.Equal(t, expect, v)
+	}
+}
+
+func TestStoreGoMap(t *testing.T) {
+	m := New(8, .9)
+	m.Store(1, 10)
+	m.Store(2, 20)
+
+	src := map[uint32]uint32{0: 1, 2: 21}
+	for i := uint32(100); i < 1100; i++ {
+		src[i] = i
+	}
+
+	m.StoreGoMap(src)
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, 1003, m.Count())
+	assert.Equal(t, arraySize(1003, .9), m.Capacity())
+	got, ok := m.Load(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(10), got)
+	for k, v := range src {
+		got, ok := m.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, got)
+	}
+}
+
+func TestMergeSorted(t *testing.T) {
+	m := New(8, .9)
+	m.Store(0, 1)
+	m.Store(5, 50)
+
+	sum := func(_, a, b uint32) uint32 { return a + b }
+	keys := []uint32{0, 1, 1, 1, 5, 7, 1000}
+	vals := []uint32{1, 10, 20, 30, 5, 70, 9}
+	m.MergeSorted(keys, vals, sum)
+
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, 5, m.Count())
+	got, ok := m.Load(0)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(2), got)
+	got, ok = m.Load(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(60), got)
+	got, ok = m.Load(5)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(55), got)
+	got, ok = m.Load(7)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(70), got)
+	got, ok = m.Load(1000)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(9), got)
+
+	// Without a resolver, the last value wins
+	m.MergeSorted([]uint32{1, 1, 2}, []uint32{3, 4, 5}, nil)
+	got, ok = m.Load(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(4), got)
+	got, ok = m.Load(2)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(5), got)
+
+	assert.Panics(t, func() { m.MergeSorted([]uint32{1}, nil, sum) })
+	assert.Panics(t, func() { m.MergeSorted([]uint32{2, 1}, []uint32{1, 1}, sum) })
+}
+
+func TestMergeSortedLarge(t *testing.T) {
+	keys := make([]uint32, 10000)
+	for i := range keys {
+		keys[i] = rand.Uint32N(5000)
+	}
+	slices.Sort(keys)
+
+	ref := make(map[uint32]uint32)
+	for _, key := range keys {
+		ref[key]++
+	}
+
+	m := New(8, .9, WithPresenceIndex())
+	m.MergeSorted(keys, slices.Repeat([]uint32{1}, len(keys)), func(_, a, b uint32) uint32 {
+		return a + b
+	})
+
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, len(ref), m.Count())
+	for k, v := range ref {
+		got, ok := m.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, got)
+	}
+}
+
+func TestExtractIf(t *testing.T) {
+	m := New(8, .99)
+	for i := uint32(0); i < 10000; i++ {
+		m.Store(i, i)
+	}
+
+	sum, calls := 0, 0
+	n := m.ExtractIf(func(key, val uint32) bool {
+		return key%3 == 0
+	}, func(key, val uint32) {
+		assert.Equal(t, key, val)
+		sum += int(val)
+		calls++
+	})
+
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, 3334, n)
+	assert.Equal(t, n, calls)
+	assert.Equal(t, 3333*3334/2*3, sum)
+	assert.Equal(t, 10000-n, m.Count())
+	m.RangeEach(func(key, _ uint32) {
+		assert.NotZero(t, key%3)
+	})
+
+	assert.Zero(t, m.ExtractIf(func(uint32, uint32) bool { return false }, nil))
+	assert.Equal(t, m.Count(), m.ExtractIf(func(uint32, uint32) bool { return true }, nil))
+	assert.Zero(t, m.Count())
+}
+
+func TestThreshold(t *testing.T) {
+	m := New(100, .5)
+	assert.Equal(t, float64(float32(.5)), m.FillFactor())
+	assert.Equal(t, 128, m.Threshold())
+
+	// Filling up to the threshold does not grow the map
+	for i := uint32(0); i < uint32(m.Threshold()); i++ {
+		m.Store(i, i)
+	}
+	assert.Equal(t, 256, m.Capacity())
+
+	m.Store(1000, 1)
+	assert.Equal(t, 512, m.Capacity())
+	assert.Equal(t, 256, m.Threshold())
+}
+
+func TestReserveExact(t *testing.T) {
+	m := New(8, .9)
+	m.Store(1, 1)
+	assert.False(t, m.ReserveExact(5))
+	assert.True(t, m.ReserveExact(1000))
+	assert.Equal(t, arraySize(1000, .9), m.Capacity())
+	assert.False(t, m.ReserveExact(1000))
+	got, ok := m.Load(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), got)
+
+	// Inserting up to the reserved total does not rehash
+	capacity := m.Capacity()
+	for i := uint32(0); i < 1000; i++ {
+		m.Store(i, i)
+	}
+	assert.Equal(t, capacity, m.Capacity())
+	assert.NoError(t, m.Validate())
+}
+
+func TestSetFillFactor(t *testing.T) {
+	m := New(900, .95)
+	for i := uint32(0); i < 900; i++ {
+		m.Store(i, i)
+	}
+	assert.Equal(t, 1024, m.Capacity())
+
+	// Lowering the fill factor grows the map right away
+	m.SetFillFactor(.5)
+	assert.Equal(t, 2048, m.Capacity())
+	assert.Equal(t, 1024, m.Threshold())
+	assert.NoError(t, m.Validate())
+
+	// Raising it keeps the capacity but raises the threshold
+	m.SetFillFactor(.9)
+	assert.Equal(t, 2048, m.Capacity())
+	assert.Equal(t, 1843, m.Threshold())
+	for i := uint32(900); i < 1843; i++ {
+		m.Store(i, i)
+	}
+	assert.Equal(t, 2048, m.Capacity())
+	assert.Equal(t, 1843, m.Count())
+
+	assert.Panics(t, func() { m.SetFillFactor(0) })
+	assert.Panics(t, func() { m.SetFillFactor(1) })
+
+	m.SetShrinkPolicy(.2)
+	assert.Panics(t, func() { m.SetFillFactor(.4) })
+}
+
+func TestSetFillFactorGrow(t *testing.T) {
+	for _, fill := range []float64{.1, .25, .5, .75} {
+		m := New(100, .99)
+		m.Store(0, 1)
+		for i := uint32(1); i < 100; i++ {
+			m.Store(i, i)
+		}
+
+		// The map grows only if the entries no longer fit below the threshold
+		capacity := m.Capacity()
+		m.SetFillFactor(fill)
+		assert.Equal(t, arraySize(100, fill) > capacity, m.Capacity() > capacity)
+		assert.GreaterOrEqual(t, m.Threshold(), m.Count())
+		assert.Equal(t, 100, m.Count())
+		assert.NoError(t, m.Validate())
+		for i := uint32(1); i < 100; i++ {
+			got, ok := m.Load(i)
+			assert.True(t, ok)
+			assert.Equal(t, i, got)
+		}
+	}
+}
+
+func TestRangeErrSkip(t *testing.T) {
+	m := sequentialMap(100)
+	visited := 0
+	err := m.RangeErrSkip(func(key, value uint32) error {
+		visited++
+		if key%2 == 0 {
+			return fmt.Errorf("key %d: %w", key, ErrSkip)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, visited)
+
+	fatal := errors.New("fatal")
+	visited = 0
+	err = m.RangeErrSkip(func(key, value uint32) error {
+		if visited++; visited == 10 {
+			return fatal
+		}
+		return ErrSkip
+	})
+	assert.Equal(t, fatal, err)
+	assert.Equal(t, 10, visited)
+}
+
+func TestMaintain(t *testing.T) {
+	m := sequentialMap(10000)
+	assert.Zero(t, m.Maintain())
+
+	capacity := m.Capacity()
+	for i := uint32(100); i < 10000; i++ {
+		m.Delete(i)
+	}
+
+	assert.Equal(t, (capacity-256)*8, m.Maintain())
+	assert.Equal(t, 256, m.Capacity())
+	assert.Zero(t, m.Maintain())
+	assert.NoError(t, m.Validate())
+	for i := uint32(0); i < 100; i++ {
+		got, ok := m.Load(i)
+		assert.True(t, ok)
+		assert.Equal(t, i, got)
+	}
+}
+
+func TestResizeFreeKeyCount(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithPresenceIndex()}, {WithProbeStride(3)}} {
+		m := New(8, .9, opts...)
+		m.Store(0, 1)
+		m.Store(math.MaxUint32, 1)
+		for i := uint32(1); i < 100000; i++ {
+			m.Store(i, i)
+			m.Store(0, i)
+			if i%1000 == 0 {
+				assert.Equal(t, int(i)+2, m.Count())
+			}
+		}
+
+		assert.Equal(t, 100001, m.Count())
+		assert.Equal(t, m.RecountLive(), m.Count())
+		assert.NoError(t, m.Validate())
+	}
+}
+
+func TestShrink(t *testing.T) {
+	m := New(8, .9)
+	for i := uint32(0); i < 10000; i++ {
+		m.Store(i, i)
+	}
+
+	capacity := m.Capacity()
+	m.Shrink()
+	assert.Equal(t, capacity, m.Capacity())
+
+	for i := uint32(100); i < 10000; i++ {
+		m.Delete(i)
+	}
+
+	m.Shrink()
+	assert.Equal(t, 128, m.Capacity())
+	assert.Equal(t, 100, m.Count())
+	assert.NoError(t, m.Validate())
+	for i := uint32(0); i < 100; i++ {
+		got, ok := m.Load(i)
+		assert.True(t, ok)
+		assert.Equal(t, i, got)
+	}
+
+	m.Clear()
+	m.Shrink()
+	assert.Equal(t, 8, m.Capacity())
+}
+
+func TestRangeBatch(t *testing.T) {
+	m := sequentialMap(1000)
+	sum, batches := 0, 0
+	m.RangeBatch(64, func(keys, vals []uint32) bool {
+		assert.Equal(t, len(keys), len(vals))
+		assert.LessOrEqual(t, len(keys), 64)
+		for i := range keys {
+			assert.Equal(t, keys[i], vals[i])
+			sum += int(keys[i])
+		}
+		batches++
+		return true
+	})
+	assert.Equal(t, 999*1000/2, sum)
+	assert.Equal(t, 16, batches)
+
+	// Stop after the first batch
+	batches = 0
+	m.RangeBatch(10, func(keys, vals []uint32) bool {
+		batches++
+		return false
+	})
+	assert.Equal(t, 1, batches)
+
+	New(8, .9).RangeBatch(10, func(keys, vals []uint32) bool {
+		assert.Fail(t, "empty map must not produce a batch")
+		return true
+	})
+	assert.Panics(t, func() { m.RangeBatch(0, nil) })
+}
+
+func TestStoreClamped(t *testing.T) {
+	m := New(8, .9)
+	m.StoreClamped(1, 50, 10)
+	m.StoreClamped(2, 5, 10)
+	got, ok := m.Load(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(10), got)
+	got, ok = m.Load(2)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(5), got)
+}
+
+func TestIncrementClamped(t *testing.T) {
+	for _, key := range []uint32{0, 1} {
+		m := New(8, .9)
+		assert.Equal(t, uint32(7), m.IncrementClamped(key, 7, 10))
+		assert.Equal(t, uint32(10), m.IncrementClamped(key, 7, 10))
+		assert.Equal(t, uint32(10), m.IncrementClamped(key, 1, 10))
+		got, ok := m.Load(key)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(10), got)
+
+		// Saturates instead of wrapping around
+		m.Store(key, math.MaxUint32-1)
+		assert.Equal(t, uint32(math.MaxUint32), m.IncrementClamped(key, 5, math.MaxUint32))
+		assert.Equal(t, uint32(3), m.IncrementClamped(key+2, 5, 3))
+		assert.Equal(t, 2, m.Count())
+	}
+}
+
+func TestBuildCounts(t *testing.T) {
+	keys := make([]uint32, 100000)
+	ref := make(map[uint32]uint32)
+	for i := range keys {
+		keys[i] = rand.Uint32N(1000)
+		ref[keys[i]]++
+	}
+
+	m := BuildCounts(keys)
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, len(ref), m.Count())
+	assert.LessOrEqual(t, m.Capacity(), arraySize(2*len(ref), .9))
+	for k, v := range ref {
+		got, ok := m.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, got)
+	}
+
+	assert.Zero(t, BuildCounts(nil).Count())
+}
+
+func TestBuildCountsRepeated(t *testing.T) {
+	keys := make([]uint32, 1000000)
+	for i := range keys {
+		keys[i] = uint32(i % 10)
+	}
+
+	// The map is never sized for all of the keys, which would take 16MB
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	m := BuildCounts(keys)
+	runtime.ReadMemStats(&after)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+
+	assert.Equal(t, 10, m.Count())
+	assert.Equal(t, arraySize(20, .9), m.Capacity())
+	got, ok := m.Load(0)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(100000), got)
+}
+
+func TestAppendSortedEntries(t *testing.T) {
+	m := randomMap(1000)
+	m.Store(0, 1)
+
+	prefix := []Entry{{Key: 99, Value: 99}}
+	out := m.AppendSortedEntries(prefix)
+	assert.Len(t, out, 1002)
+	assert.Equal(t, Entry{Key: 99, Value: 99}, out[0])
+	assert.Equal(t, Entry{Key: 0, Value: 1}, out[1])
+	assert.True(t, slices.IsSortedFunc(out[1:], func(a, b Entry) int {
+		return cmp.Compare(a.Key, b.Key)
+	}))
+
+	// Reusing the buffer does not allocate
+	buffer := make([]Entry, 0, m.Count())
+	assert.Zero(t, testing.AllocsPerRun(10, func() {
+		buffer = m.AppendSortedEntries(buffer[:0])
+	}))
+}
+
+func TestSwapContents(t *testing.T) {
+	building, serving := New(8, .9, WithPresenceIndex()), sequentialMap(100)
+	building.Store(0, 42)
+
+	data := building.Raw()
+	building.SwapContents(serving)
+	assert.Same(t, &data[0], &serving.Raw()[0])
+	assert.Equal(t, 100, building.Count())
+	assert.Equal(t, 1, serving.Count())
+	got, ok := serving.Load(0)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(42), got)
+	got, ok = building.Load(99)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(99), got)
+	assert.NoError(t, building.Validate())
+	assert.NoError(t, serving.Validate())
+}
+
+func TestCapacityFor(t *testing.T) {
+	for _, size := range []int{1, 7, 100, 1000, 123456} {
+		for _, fill := range []float64{.5, .75, .9, .99} {
+			assert.Equal(t, New(size, fill).Capacity(), CapacityFor(size, fill))
+		}
+	}
+
+	assert.Panics(t, func() { CapacityFor(0, .9) })
+	assert.Panics(t, func() { CapacityFor(10, 1) })
+}
+
+func TestTrimToTopN(t *testing.T) {
+	m := New(8, .9)
+	for i := uint32(0); i < 1000; i++ {
+		m.Store(i, i%100)
+	}
+
+	m.TrimToTopN(15)
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, 15, m.Count())
+	for i := uint32(0); i < 1000; i++ {
+		_, ok := m.Load(i)
+		assert.Equal(t, i%100 == 99 || (i%100 == 98 && i < 500), ok, "key %d", i)
+	}
+
+	m.TrimToTopN(100)
+	assert.Equal(t, 15, m.Count())
+	m.TrimToTopN(0)
+	assert.Zero(t, m.Count())
+}
+
+func TestOf(t *testing.T) {
+	m := Of([2]uint32{0, 1}, [2]uint32{5, 50}, [2]uint32{5, 55}, [2]uint32{7, 70})
+	assert.Equal(t, 3, m.Count())
+	assert.Equal(t, CapacityFor(4, .9), m.Capacity())
+	got, ok := m.Load(0)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), got)
+	got, ok = m.Load(5)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(55), got)
+	got, ok = m.Load(7)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(70), got)
+	assert.Zero(t, Of().Count())
+}
+
+func TestRangeKeys(t *testing.T) {
+	m := sequentialMap(1000)
+	sum := 0
+	m.RangeKeys(100, 199, func(key, value uint32) bool {
+		assert.True(t, key >= 100 && key <= 199)
+		sum += int(key)
+		return true
+	})
+	assert.Equal(t, 14950, sum)
+
+	// The 'free' key is only included if zero is within the range
+	for _, lo := range []uint32{0, 1} {
+		count := 0
+		m.RangeKeys(lo, 9, func(key, value uint32) bool {
+			count++
+			return true
+		})
+		assert.Equal(t, 10-int(lo), count)
+	}
+
+	count := 0
+	m.RangeKeys(0, math.MaxUint32, func(key, value uint32) bool {
+		count++
+		return count < 5
+	})
+	assert.Equal(t, 5, count)
+}
+
+func TestLoadAndReset(t *testing.T) {
+	for _, key := range []uint32{0, 1} {
+		m := New(8, .9)
+		m.Store(key, 10)
+		capacity := m.Capacity()
+
+		v, ok := m.LoadAndReset(key)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(10), v)
+
+		v, ok = m.Load(key)
+		assert.True(t, ok)
+		assert.Zero(t, v)
+
+		_, ok = m.LoadAndReset(key + 2)
+		assert.False(t, ok)
+		assert.Equal(t, 1, m.Count())
+		assert.Equal(t, capacity, m.Capacity())
+
+		// A deleted key does not return its stale value
+		m.Store(key, 5)
+		m.Delete(key)
+		v, ok = m.LoadAndReset(key)
+		assert.False(t, ok)
+		assert.Zero(t, v)
+		assert.Zero(t, m.Count())
+	}
+}
+
+func TestTakeValues(t *testing.T) {
+	m := sequentialMap(1000)
+	m.Store(0, 5)
+
+	out := m.TakeValues()
+	assert.Len(t, out, 1000)
+	assert.Equal(t, uint32(5), out[0])
+	assert.Equal(t, uint32(999), out[999])
+
+	assert.Equal(t, 1000, m.Count())
+	m.RangeEach(func(key, value uint32) {
+		assert.Zero(t, value)
+	})
+
+	m.Store(3, 3)
+	m.ResetValues()
+	assert.Zero(t, m.SumValues())
+	assert.Equal(t, 1000, m.Count())
+}
+
+func TestSumMaps(t *testing.T) {
+	a, b, c := sequentialMap(100), sequentialMap(1000), New(8, .9)
+	c.Store(0, 7)
+	c.Store(5000, math.MaxUint32)
+	b.Store(5000, 2)
+
+	out := SumMaps(a, b, c)
+	assert.NoError(t, out.Validate())
+	assert.Equal(t, 1001, out.Count())
+	got, ok := out.Load(0)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(7), got)
+	got, ok = out.Load(50)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(2*50), got)
+	got, ok = out.Load(500)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(500), got)
+	got, ok = out.Load(5000) // wraps around
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), got)
+
+	assert.Zero(t, SumMaps().Count())
+}
+
+func TestChecksum(t *testing.T) {
+	a := randomMap(1000)
+	a.Store(0, 1)
+
+	// Same contents with a different layout
+	b := New(8, .5, WithPresenceIndex(), WithProbeStride(3))
+	a.RangeEach(b.Store)
+	assert.Equal(t, a.Checksum(), b.Checksum())
+
+	// Any difference changes the checksum, including the 'free' key
+	b.Store(0, 2)
+	assert.NotEqual(t, a.Checksum(), b.Checksum())
+	b.Delete(0)
+	assert.NotEqual(t, a.Checksum(), b.Checksum())
+	b.Store(0, 1)
+	assert.Equal(t, a.Checksum(), b.Checksum())
+
+	// Swapping the values of two keys changes the checksum
+	c, d := Of([2]uint32{1, 2}, [2]uint32{2, 1}), Of([2]uint32{1, 1}, [2]uint32{2, 2})
+	assert.NotEqual(t, c.Checksum(), d.Checksum())
+	assert.Zero(t, New(8, .9).Checksum())
+	assert.NotZero(t, Of([2]uint32{0, 0}).Checksum())
+}
+
+func TestMapLoadOrStore(t *testing.T) {
+	for _, key := range []uint32{0, 1} {
+		m := New(8, .9)
+		calls := 0
+		fn := func() uint32 {
+			calls++
+			return 10
+		}
+
+		v, loaded := m.LoadOrStore(key, fn)
+		assert.False(t, loaded)
+		assert.Equal(t, uint32(10), v)
+
+		m.Store(key, 20)
+		v, loaded = m.LoadOrStore(key, fn)
+		assert.True(t, loaded)
+		assert.Equal(t, uint32(20), v)
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, 1, m.Count())
+	}
+}
+
+func TestLoadAndDelete(t *testing.T) {
+	m := sequentialMap(1000)
+	for i := uint32(0); i < 1000; i += 2 {
+		v, ok := m.LoadAndDelete(i)
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+
+		v, ok = m.LoadAndDelete(i)
+		assert.False(t, ok)
+		assert.Zero(t, v)
+	}
+
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, 500, m.Count())
+	for i := uint32(1); i < 1000; i += 2 {
+		got, ok := m.Load(i)
+		assert.True(t, ok)
+		assert.Equal(t, i, got)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	for _, key := range []uint32{0, 1} {
+		m := New(8, .9)
+		prev, loaded := m.Swap(key, 10)
+		assert.False(t, loaded)
+		assert.Zero(t, prev)
+
+		prev, loaded = m.Swap(key, 20)
+		assert.True(t, loaded)
+		assert.Equal(t, uint32(10), prev)
+		got, ok := m.Load(key)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(20), got)
+
+		// A deleted key is inserted afresh, without its stale value
+		m.Store(key, 5)
+		m.Delete(key)
+		prev, loaded = m.Swap(key, 7)
+		assert.False(t, loaded)
+		assert.Zero(t, prev)
+		got, ok = m.Load(key)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(7), got)
+	}
+}
+
+func TestAllKeysValues(t *testing.T) {
+	m := sequentialMap(100)
+	sum, count := 0, 0
+	for key, value := range m.All() {
+		assert.Equal(t, key, value)
+		count++
+	}
+	assert.Equal(t, 100, count)
+
+	count = 0
+	for key := range m.Keys() {
+		sum += int(key)
+		count++
+	}
+	assert.Equal(t, 100, count)
+	assert.Equal(t, 99*100/2, sum)
+
+	sum = 0
+	for value := range m.Values() {
+		sum += int(value)
+	}
+	assert.Equal(t, 99*100/2, sum)
+
+	// Breaking out of the loop stops the iteration
+	count = 0
+	for range m.Keys() {
+		if count++; count == 10 {
+			break
+		}
+	}
+	assert.Equal(t, 10, count)
+}
+
+func TestKeySliceValueSlice(t *testing.T) {
+	m := New(8, .9)
+	assert.Empty(t, m.KeySlice())
+	assert.Empty(t, m.ValueSlice())
+
+	for i := uint32(0); i < 100; i++ {
+		m.Store(i, i*2)
+	}
+
+	keys, vals := m.KeySlice(), m.ValueSlice()
+	assert.Len(t, keys, 100)
+	assert.Equal(t, 100, cap(keys))
+	assert.Equal(t, 100, cap(vals))
+	for i := range keys {
+		assert.Equal(t, keys[i]*2, vals[i])
+	}
+	assert.Contains(t, keys, uint32(0))
+}
+
+func TestUpdate(t *testing.T) {
+	m := New(8, .9)
+	ref := make(map[uint32]uint32)
+	for i := 0; i < 10000; i++ {
+		key := rand.Uint32N(1000)
+		m.Update(key, func(old uint32, loaded bool) uint32 {
+			prev, ok := ref[key]
+			assert.Equal(t, ok, loaded)
+			assert.Equal(t, prev, old)
+			return old + 1
+		})
+		ref[key]++
+	}
+
+	assert.NoError(t, m.Validate())
+	assert.Equal(t, len(ref), m.Count())
+	for k, v := range ref {
+		got, ok := m.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b := New(8, .9), New(8, .9)
+	for i := uint32(0); i < 1000; i++ {
+		a.Store(i, 1)
+		b.Store(i+500, 2)
+	}
+
+	sum := func(a, b uint32) uint32 { return a + b }
+	a.Merge(b, sum)
+	assert.NoError(t, a.Validate())
+	assert.Equal(t, 1500, a.Count())
+	assert.Equal(t, 1000, b.Count())
+	for i := uint32(0); i < 1500; i++ {
+		switch {
+		case i < 500:
+			got, ok := a.Load(i)
+			assert.True(t, ok)
+			assert.Equal(t, uint32(1), got)
+		case i < 1000:
+			got, ok := a.Load(i)
+			assert.True(t, ok)
+			assert.Equal(t, uint32(3), got)
+		default:
+			got, ok := a.Load(i)
+			assert.True(t, ok)
+			assert.Equal(t, uint32(2), got)
+		}
+	}
+
+	// The 'free' key of the other map is merged as well
+	c := New(8, .9)
+	c.Merge(a, sum)
+	c.Merge(a, sum)
+	got, ok := c.Load(0)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(2), got)
+}
+
+func TestEqual(t *testing.T) {
+	a, b := New(8, .9), New(1000, .5, WithProbeStride(3))
+	assert.True(t, a.Equal(b))
+
+	for i := uint32(0); i < 500; i++ {
+		a.Store(i, i)
+		b.Store(499-i, 499-i)
+	}
+	assert.True(t, a.Equal(b))
+	assert.True(t, b.Equal(a))
+
+	b.Store(0, 1)
+	assert.False(t, a.Equal(b))
+	b.Store(0, 0)
+	b.Delete(1)
+	assert.False(t, a.Equal(b))
+	b.Store(500, 1)
+	assert.False(t, a.Equal(b))
+	assert.False(t, b.Equal(a))
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	for _, key := range []uint32{0, 1} {
+		m := New(8, .9)
+		assert.False(t, m.CompareAndSwap(key, 0, 1))
+		assert.Zero(t, m.Count())
+
+		m.Store(key, 10)
+		assert.False(t, m.CompareAndSwap(key, 5, 20))
+		got, ok := m.Load(key)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(10), got)
+		assert.True(t, m.CompareAndSwap(key, 10, 20))
+		got, ok = m.Load(key)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(20), got)
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	for _, key := range []uint32{0, 1} {
+		m := New(8, .9)
+		assert.False(t, m.CompareAndDelete(key, 0))
+
+		m.Store(key, 10)
+		m.Store(key+2, 30)
+		assert.False(t, m.CompareAndDelete(key, 5))
+		assert.Equal(t, 2, m.Count())
+		assert.True(t, m.CompareAndDelete(key, 10))
+		assert.Equal(t, 1, m.Count())
+
+		_, ok := m.Load(key)
+		assert.False(t, ok)
+		assert.NoError(t, m.Validate())
+	}
+}
+
+func TestGetOrDefault(t *testing.T) {
+	m := New(8, .9)
+	assert.Equal(t, uint32(7), m.GetOrDefault(0, 7))
+	assert.Equal(t, uint32(7), m.GetOrDefault(1, 7))
+
+	m.Store(0, 0)
+	m.Store(1, 10)
+	assert.Equal(t, uint32(0), m.GetOrDefault(0, 7))
+	assert.Equal(t, uint32(10), m.GetOrDefault(1, 7))
+	assert.Equal(t, 2, m.Count())
+}
+
+func TestContains(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithPresenceIndex()}, {WithProbeStride(3)}} {
+		m := New(8, .9, opts...)
+		assert.False(t, m.Contains(0))
+		for i := uint32(0); i < 1000; i += 2 {
+			m.Store(i, i)
+		}
+
+		for i := uint32(0); i < 1000; i++ {
+			_, ok := m.Load(i)
+			assert.Equal(t, ok, m.Contains(i))
+			assert.Equal(t, i%2 == 0, m.Contains(i))
+		}
+	}
+}
+
+func TestContainsMany(t *testing.T) {
+	m := sequentialMap(10)
+	assert.Equal(t, []bool{true, true, false, true, false},
+		m.ContainsMany([]uint32{0, 9, 10, 5, 100}))
+	assert.Empty(t, m.ContainsMany(nil))
+}
+
+// exporters are all of the ways to read the entries out of a map, each of them must
+// return the free key exactly once. New iteration and export paths should be added.
+var exporters = map[string]func(*Map) [][2]uint32{
+	"Range": collect,
+	"ForEach": func(m *Map) (out [][2]uint32) {
+		m.ForEach(func(key, value uint32) {
+			out = append(out, [2]uint32{key, value})
+		})
+		return
+	},
+	"RangeBatch": func(m *Map) (out [][2]uint32) {
+		m.RangeBatch(3, func(keys, vals []uint32) bool {
+			for i := range keys {
+				out = append(out, [2]uint32{keys[i], vals[i]})
+			}
+			return true
+		})
+		return
+	},
+	"AppendSortedEntries": func(m *Map) (out [][2]uint32) {
+		for _, e := range m.AppendSortedEntries(nil) {
+			out = append(out, [2]uint32{e.Key, e.Value})
+		}
+		return
+	},
+	"Cursor": func(m *Map) (out [][2]uint32) {
+		for c := m.Cursor(); c.Next(); {
+			out = append(out, [2]uint32{c.Key(), c.Value()})
+		}
+		return
+	},
+	"RangeEach": func(m *Map) (out [][2]uint32) {
+		m.RangeEach(func(key, value uint32) {
+			out = append(out, [2]uint32{key, value})
+		})
+		return
+	},
+	"RangeErr": func(m *Map) (out [][2]uint32) {
+		m.RangeErr(func(key, value uint32) error {
+			out = append(out, [2]uint32{key, value})
+			return nil
+		})
+		return
+	},
+	"RangeSlots": func(m *Map) (out [][2]uint32) {
+		m.RangeSlots(func(_ int, key, value uint32, _ int) bool {
+			out = append(out, [2]uint32{key, value})
+			return true
+		})
+		return
+	},
+	"RangeByBucket": func(m *Map) (out [][2]uint32) {
+		m.RangeByBucket(func(_ int, key, value uint32) bool {
+			out = append(out, [2]uint32{key, value})
+			return true
+		})
+		return
+	},
+	"Keys/Values": func(m *Map) (out [][2]uint32) {
+		keys, vals := slices.Collect(m.Keys()), slices.Collect(m.Values())
+		for i := range keys {
+			out = append(out, [2]uint32{keys[i], vals[i]})
+		}
+		return
+	},
+	"KeySlice/ValueSlice": func(m *Map) (out [][2]uint32) {
+		keys, vals := m.KeySlice(), m.ValueSlice()
+		for i := range keys {
+			out = append(out, [2]uint32{keys[i], vals[i]})
+		}
+		return
+	},
+	"RangeKeys": func(m *Map) (out [][2]uint32) {
+		m.RangeKeys(0, math.MaxUint32, func(key, value uint32) bool {
+			out = append(out, [2]uint32{key, value})
+			return true
+		})
+		return
+	},
+	"TakeValues": func(m *Map) (out [][2]uint32) {
+		for key, value := range m.Clone().TakeValues() {
+			out = append(out, [2]uint32{key, value})
+		}
+		return
+	},
+	"All": func(m *Map) (out [][2]uint32) {
+		for key, value := range m.All() {
+			out = append(out, [2]uint32{key, value})
+		}
+		return
+	},
+	"Sync.RangeWeak": func(m *Map) (out [][2]uint32) {
+		(&Sync{data: m}).RangeWeak(func(key, value uint32) bool {
+			out = append(out, [2]uint32{key, value})
+			return true
+		})
+		return
+	},
+	"Sync.All": func(m *Map) (out [][2]uint32) {
+		for key, value := range (&Sync{data: m}).All() {
+			out = append(out, [2]uint32{key, value})
+		}
+		return
+	},
+	"Clone": func(m *Map) [][2]uint32 {
+		return collect(m.Clone())
+	},
+	"TakeAll": func(m *Map) [][2]uint32 {
+		return collect(m.Clone().TakeAll())
+	},
+	"Rekey": func(m *Map) [][2]uint32 {
+		return collect(m.Rekey(func(key uint32) uint32 { return key }, nil))
+	},
+	"MergeMax": func(m *Map) [][2]uint32 {
+		out := New(10, .9)
+		out.MergeMax(m)
+		return collect(out)
+	},
+	"Merge": func(m *Map) [][2]uint32 {
+		out := New(10, .9)
+		out.Merge(m, func(a, b uint32) uint32 { return a + b })
+		return collect(out)
+	},
+	"MarshalKeys": func(m *Map) [][2]uint32 {
+		out, _ := UnmarshalKeys(m.MarshalKeys(), 1)
+		return collect(out)
+	},
+	"MarshalCompact": func(m *Map) [][2]uint32 {
+		out, _ := UnmarshalCompact(m.MarshalCompact())
+		return collect(out)
+	},
+	"MarshalPortable": func(m *Map) [][2]uint32 {
+		out, _ := UnmarshalPortable(m.MarshalPortable())
+		return collect(out)
+	},
+	"MarshalJSON": func(m *Map) [][2]uint32 {
+		data, _ := m.MarshalJSON()
+		out := new(Map)
+		out.UnmarshalJSON(data)
+		return collect(out)
+	},
+	"MarshalBinary": func(m *Map) [][2]uint32 {
+		data, _ := m.MarshalBinary()
+		out := new(Map)
+		out.UnmarshalBinary(data)
+		return collect(out)
+	},
+}
+
+// collect returns all of the entries of the map
+func collect(m *Map) (out [][2]uint32) {
+	m.Range(func(key, value uint32) bool {
+		out = append(out, [2]uint32{key, value})
+		return true
+	})
+	return
+}
+
+func TestFreeKeyExport(t *testing.T) {
+	m := New(10, .9)
+	m.Store(0, 1)
+	m.Store(1, 1)
+	m.Store(2, 1)
+
+	for name, export := range exporters {
+		t.Run(name, func(t *testing.T) {
+			entries := export(m)
+			assert.Len(t, entries, 3)
+			assert.ElementsMatch(t, [][2]uint32{{0, 1}, {1, 1}, {2, 1}}, entries)
+		})
+	}
+}
+
+func TestFreeKeyOnly(t *testing.T) {
+	m := New(10, .9)
+	m.Store(0, 1)
+
+	for name, export := range exporters {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, [][2]uint32{{0, 1}}, export(m))
+		})
+	}
+}
+
+func TestDeleteObserve(t *testing.T) {
+	m := randomMap(1000)
+	m.Store(0, 1)
+
+	shifted := 0
+	m.Range(func(key, value uint32) bool {
+		before := m.Clone()
+		assert.True(t, m.DeleteObserve(key, func(from, to int, moved uint32) {
+			assert.Equal(t, moved, before.data[2*from])
+			assert.Equal(t, moved, m.data[2*to])
+			shifted++
+		}))
+		assert.False(t, m.DeleteObserve(key, nil))
+		return m.Count() > 500
+	})
+
+	assert.Greater(t, shifted, 0)
+	assert.NoError(t, m.Validate())
+}
+
+func TestJaccard(t *testing.T) {
+	a, b := New(10, .9), New(10, .9)
+	assert.Equal(t, 1.0, a.Jaccard(b))
+
+	for i := uint32(0); i < 100; i++ {
+		a.Store(i, i)
+		b.Store(i+50, 0)
+	}
+
+	assert.InDelta(t, 50.0/150, a.Jaccard(b), 1e-9)
+	assert.InDelta(t, 50.0/150, b.Jaccard(a), 1e-9)
+	assert.Equal(t, 1.0, a.Jaccard(a.Clone()))
+	assert.Equal(t, 0.0, a.Jaccard(New(10, .9)))
+}
+
+func TestRangeValue(t *testing.T) {
+	m := New(10, .9)
+	for i := uint32(0); i < 100; i++ {
+		m.Store(i, i%3)
+	}
+
+	keys := []uint32{}
+	m.RangeValue(0, func(key uint32) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	assert.Len(t, keys, 34)
+	assert.Contains(t, keys, uint32(0))
+	for _, key := range keys {
+		assert.Equal(t, uint32(0), key%3)
+	}
+
+	count := 0
+	m.RangeValue(1, func(key uint32) bool {
+		count++
+		return false
+	})
+	assert.Equal(t, 1, count)
+}
+
+func TestArraySizeOverflow(t *testing.T) {
+	assert.Equal(t, maxCapacity, arraySize(math.MaxInt32, .01))
+	assert.Equal(t, maxCapacity, arraySize(maxCapacity, .99))
+	assert.Equal(t, 1<<20, arraySize(1<<20, 1))
+	assert.Equal(t, 1<<21, arraySize(1<<20+1, 1))
+}
+
+func TestGrowHint(t *testing.T) {
+	m := sequentialMap(100)
+	m.GrowHint(1000)
+	assert.Equal(t, 2*arraySize(1000, .99), m.Capacity())
+	assert.Equal(t, 100, m.Count())
+	assert.NoError(t, m.Validate())
+
+	// Never shrinks
+	capacity := m.Capacity()
+	m.GrowHint(10)
+	m.GrowHint(0)
+	assert.Equal(t, capacity, m.Capacity())
+
+	for i := uint32(100); i < 1000; i++ {
+		m.Store(i, i)
+	}
+	assert.Equal(t, capacity, m.Capacity())
+}
+
+func TestRawNextSlot(t *testing.T) {
+	m := randomMap(100)
+	raw := m.Raw()
+	assert.Len(t, raw, 2*m.Capacity())
+	assert.Equal(t, 1, m.NextSlot(0))
+	assert.Equal(t, 0, m.NextSlot(m.Capacity()-1))
+
+	// Walking the probe sequence must find every key
+	m.RangeEach(func(key, value uint32) {
+		slot := HomeBucket(key, m.Capacity())
+		for raw[2*slot] != key {
+			assert.NotEqual(t, uint32(isFree), raw[2*slot])
+			slot = m.NextSlot(slot)
+		}
+		assert.Equal(t, value, raw[2*slot+1])
+	})
+}
+
+func TestAdd(t *testing.T) {
+	m := New(4, .9)
+	for i := 0; i < 3; i++ {
+		for k := uint32(0); k < 100; k++ {
+			assert.Equal(t, k*uint32(i+1), m.Add(k, k))
+		}
+	}
+
+	assert.Equal(t, 100, m.Count())
+	assert.Equal(t, uint32(1), m.Add(0, 1))
+	assert.Equal(t, uint32(0), m.Add(1, math.MaxUint32-2))
+}
+
+func TestSwapOrStoreDuplicates(t *testing.T) {
+	keys := []uint32{0, 1, 2, 3, 1, 0, 4, 1}
+	m := New(4, .9)
+
+	duplicates := 0
+	for i, key := range keys {
+		if _, dup := m.SwapOrStore(key, uint32(i)); dup {
+			duplicates++
+		}
+	}
+
+	assert.Equal(t, 3, duplicates)
+	assert.Equal(t, 5, m.Count())
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import "slices"
+
+// MultiMap is a map-like data-structure where each uint32 key maps to a list of uint32
+// values. It uses the same probing as Map, with each slot holding a slice header of 24
+// bytes that points to a separately allocated list of values, which grows by doubling
+// as values are added. Keys with many values are therefore cheap to probe, but keys
+// with a single value cost an allocation each.
+type MultiMap struct {
+	Table[uint32, []uint32]
+}
+
+// NewMultiMap returns a multimap initialized with n spaces and uses the stated
+// fillFactor. The multimap will grow as needed.
+func NewMultiMap(size int, fillFactor float64) *MultiMap {
+	return &MultiMap{
+		Table: *NewTable[uint32, []uint32](size, fillFactor),
+	}
+}
+
+// Add appends a value to the list of values of a key.
+func (m *MultiMap) Add(key, val uint32) {
+	values := m.upsert(key)
+	*values = append(*values, val)
+}
+
+// Get returns the values of a key, in the order they were added, or nil if the key
+// is not present. The returned slice must not be modified.
+func (m *MultiMap) Get(key uint32) []uint32 {
+	values, _ := m.Load(key)
+	return values
+}
+
+// Remove removes the first occurrence of a value from the list of values of a key, and
+// returns whether it was found. The key is deleted once it no longer has any values.
+// The remaining values are copied, so slices previously returned by Get are unchanged.
+func (m *MultiMap) Remove(key, val uint32) bool {
+	values, ok := m.Load(key)
+	if !ok {
+		return false
+	}
+
+	i := slices.Index(values, val)
+	switch {
+	case i < 0:
+		return false
+	case len(values) == 1:
+		m.Delete(key)
+	default:
+		m.Store(key, slices.Delete(slices.Clone(values), i, i+1))
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiMap(t *testing.T) {
+	m := NewMultiMap(8, .9)
+	for i := uint32(0); i < 1000; i++ {
+		m.Add(i%10, i)
+	}
+
+	assert.Equal(t, 10, m.Count())
+	for k := uint32(0); k < 10; k++ {
+		values := m.Get(k)
+		assert.Len(t, values, 100)
+		assert.Equal(t, k, values[0])
+		assert.Equal(t, k+990, values[99])
+	}
+
+	// Remove a single value
+	assert.True(t, m.Remove(1, 11))
+	assert.False(t, m.Remove(1, 11))
+	assert.False(t, m.Remove(100, 1))
+	assert.Len(t, m.Get(1), 99)
+	assert.Equal(t, uint32(21), m.Get(1)[1])
+
+	// Delete drops every value
+	m.Delete(2)
+	assert.Nil(t, m.Get(2))
+	assert.Equal(t, 9, m.Count())
+}
+
+func TestMultiMapRemoveLast(t *testing.T) {
+	m := NewMultiMap(8, .9)
+	m.Add(0, 5)
+	m.Add(0, 6)
+	assert.True(t, m.Remove(0, 5))
+	assert.True(t, m.Remove(0, 6))
+	assert.Zero(t, m.Count())
+	assert.Nil(t, m.Get(0))
+}
+
+func TestMultiMapRemoveCopies(t *testing.T) {
+	m := NewMultiMap(8, .9)
+	m.Add(1, 10)
+	m.Add(1, 20)
+	m.Add(1, 30)
+
+	before := m.Get(1)
+	assert.True(t, m.Remove(1, 10))
+	assert.Equal(t, []uint32{10, 20, 30}, before)
+	assert.Equal(t, []uint32{20, 30}, m.Get(1))
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+// Nested is a two-level map, where keys are grouped by a namespace and each namespace
+// is backed by its own Map. Keys of different namespaces never collide with each other
+// and the map of a namespace is created on the first write and released once empty.
+type Nested struct {
+	spaces     *Table[uint32, *Map] // Maps of every namespace
+	size       int                  // Initial size of a namespace
+	fillFactor float64              // Fill factor of every map
+	count      int                  // Number of elements in all namespaces
+}
+
+// NewNested returns a nested map, where the map of each namespace is initialized with
+// n spaces and uses the stated fillFactor.
+func NewNested(size int, fillFactor float64) *Nested {
+	return &Nested{
+		spaces:     NewTable[uint32, *Map](8, fillFactor),
+		size:       size,
+		fillFactor: fillFactor,
+	}
+}
+
+// Load returns the value stored for a key in the namespace. The ok result indicates
+// whether value was found in the map.
+func (m *Nested) Load(ns, key uint32) (uint32, bool) {
+	if inner, ok := m.spaces.Load(ns); ok {
+		return inner.Load(key)
+	}
+	return 0, false
+}
+
+// Store sets the value for a key in the namespace, creating its map if necessary.
+func (m *Nested) Store(ns, key, val uint32) {
+	inner := m.spaces.upsert(ns)
+	if *inner == nil {
+		*inner = New(m.size, m.fillFactor)
+	}
+
+	count := (*inner).Count()
+	(*inner).Store(key, val)
+	m.count += (*inner).Count() - count
+}
+
+// Delete deletes the value for a key in the namespace. The map of the namespace is
+// removed once it becomes empty.
+func (m *Nested) Delete(ns, key uint32) {
+	inner, ok := m.spaces.Load(ns)
+	if !ok || !inner.DeleteObserve(key, nil) {
+		return
+	}
+
+	m.count--
+	if inner.Count() == 0 {
+		m.spaces.Delete(ns)
+	}
+}
+
+// Count returns number of key/value pairs in all of the namespaces.
+func (m *Nested) Count() int {
+	return m.count
+}
+
+// Namespaces returns the number of namespaces which hold at least one key.
+func (m *Nested) Namespaces() int {
+	return m.spaces.Count()
+}
+
+// Map returns the map of a namespace, or nil if the namespace is empty. The returned
+// map must not be modified, since the count of the nested map would become stale.
+func (m *Nested) Map(ns uint32) *Map {
+	inner, _ := m.spaces.Load(ns)
+	return inner
+}
+
+// Range calls f sequentially for each namespace, key and value present in the map. If
+// f returns false, range stops the iteration.
+func (m *Nested) Range(f func(ns, key, value uint32) bool) {
+	m.spaces.Range(func(ns uint32, inner *Map) bool {
+		next := true
+		inner.Range(func(key, value uint32) bool {
+			next = f(ns, key, value)
+			return next
+		})
+		return next
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNested(t *testing.T) {
+	m := NewNested(8, .9)
+	m.Store(1, 5, 10)
+	m.Store(2, 5, 20)
+	m.Store(0, 0, 30)
+	assert.Equal(t, 3, m.Count())
+	assert.Equal(t, 3, m.Namespaces())
+
+	v, ok := m.Load(1, 5)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(10), v)
+
+	v, ok = m.Load(2, 5)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(20), v)
+
+	_, ok = m.Load(3, 5)
+	assert.False(t, ok)
+
+	// Overwriting does not change the count
+	m.Store(1, 5, 11)
+	assert.Equal(t, 3, m.Count())
+
+	// Deleting the last key of a namespace releases its map
+	m.Delete(1, 6)
+	m.Delete(3, 5)
+	assert.Equal(t, 3, m.Count())
+	m.Delete(1, 5)
+	assert.Equal(t, 2, m.Count())
+	assert.Equal(t, 2, m.Namespaces())
+	assert.Nil(t, m.Map(1))
+	assert.NotNil(t, m.Map(2))
+}
+
+func TestNestedRandom(t *testing.T) {
+	type key struct{ ns, key uint32 }
+	m := NewNested(8, .9)
+	ref := make(map[key]uint32)
+	for i := 0; i < 50000; i++ {
+		k := key{rand.Uint32N(20), rand.Uint32N(500)}
+		if rand.IntN(3) == 0 {
+			m.Delete(k.ns, k.key)
+			delete(ref, k)
+			continue
+		}
+
+		m.Store(k.ns, k.key, uint32(i))
+		ref[k] = uint32(i)
+	}
+
+	count := 0
+	m.Range(func(ns, k, v uint32) bool {
+		assert.Equal(t, ref[key{ns, k}], v)
+		count++
+		return true
+	})
+	assert.Equal(t, len(ref), count)
+	assert.Equal(t, len(ref), m.Count())
+
+	count = 0
+	m.Range(func(_, _, _ uint32) bool {
+		count++
+		return count < 10
+	})
+	assert.Equal(t, 10, count)
+}
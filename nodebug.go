@@ -0,0 +1,9 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+//go:build !intmap_debug
+
+package intmap
+
+// assertCount checks the count of the map, which is disabled without the
+// intmap_debug build tag.
+func (m *Map) assertCount(expect int32) {}
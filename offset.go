@@ -0,0 +1,55 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+// OffsetMap is a map of uint32 keys and values which shifts every key by one before
+// it reaches the underlying Map, and back on iteration. The key zero is then stored in
+// the backing array like any other key instead of going through the separate path of
+// the 'free' key, which is useful when zero is a frequent key. The only key taking that
+// path is math.MaxUint32, which wraps around to zero, so the full range of keys remains
+// available. The default Map is not affected by the shift.
+type OffsetMap struct {
+	data *Map
+}
+
+// NewOffsetMap returns an offset map initialized with n spaces and uses the stated
+// fillFactor and options. The map will grow as needed.
+func NewOffsetMap(size int, fillFactor float64, options ...Option) *OffsetMap {
+	return &OffsetMap{
+		data: New(size, fillFactor, options...),
+	}
+}
+
+// Count returns number of key/value pairs in the map.
+func (m *OffsetMap) Count() int {
+	return m.data.Count()
+}
+
+// Load returns the value stored in the map for a key, or zero if no value is present.
+// The ok result indicates whether value was found in the map.
+func (m *OffsetMap) Load(key uint32) (uint32, bool) {
+	return m.data.Load(key + 1)
+}
+
+// Store sets the value for a key.
+func (m *OffsetMap) Store(key, val uint32) {
+	m.data.Store(key+1, val)
+}
+
+// Delete deletes the value for a key.
+func (m *OffsetMap) Delete(key uint32) {
+	m.data.Delete(key + 1)
+}
+
+// Range calls f sequentially for each key and value present in the map. If fn
+// returns false, range stops the iteration.
+func (m *OffsetMap) Range(fn func(key, value uint32) bool) {
+	m.data.Range(func(key, value uint32) bool {
+		return fn(key-1, value)
+	})
+}
+
+// Clear removes all entries from the map.
+func (m *OffsetMap) Clear() {
+	m.data.Clear()
+}
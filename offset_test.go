@@ -0,0 +1,91 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkOffsetMap/map      	100000000	        11.99 ns/op	       0 B/op	       0 allocs/op
+BenchmarkOffsetMap/offset   	100000000	        11.86 ns/op	       0 B/op	       0 allocs/op
+*/
+func BenchmarkOffsetMap(b *testing.B) {
+	const count = 1000
+	plain := New(count, .9)
+	offset := NewOffsetMap(count, .9)
+	for i := uint32(0); i < count; i++ {
+		plain.Store(i, i)
+		offset.Store(i, i)
+	}
+
+	// Half of the lookups are for the key zero
+	b.Run("map", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			plain.Load(uint32(i&1) * rand.Uint32N(count))
+		}
+	})
+
+	b.Run("offset", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			offset.Load(uint32(i&1) * rand.Uint32N(count))
+		}
+	})
+}
+
+func TestOffsetMap(t *testing.T) {
+	m := NewOffsetMap(8, .9, WithPresenceIndex())
+	ref := make(map[uint32]uint32)
+	for i := 0; i < 100000; i++ {
+		key := rand.Uint32N(5000) - 2500 // wraps around zero
+		if rand.IntN(3) == 0 {
+			m.Delete(key)
+			delete(ref, key)
+			continue
+		}
+
+		m.Store(key, uint32(i))
+		ref[key] = uint32(i)
+	}
+
+	assert.NoError(t, m.data.Validate())
+	assert.Equal(t, len(ref), m.Count())
+	m.Range(func(key, value uint32) bool {
+		assert.Equal(t, ref[key], value)
+		return true
+	})
+
+	m.Clear()
+	assert.Zero(t, m.Count())
+}
+
+func TestOffsetMapFreeKey(t *testing.T) {
+	m := NewOffsetMap(8, .9)
+	m.Store(0, 1)
+	assert.False(t, m.data.hasFreeKey)
+	m.Store(math.MaxUint32, 2)
+	assert.True(t, m.data.hasFreeKey)
+
+	var out [][2]uint32
+	m.Range(func(key, value uint32) bool {
+		out = append(out, [2]uint32{key, value})
+		return true
+	})
+	assert.ElementsMatch(t, [][2]uint32{{0, 1}, {math.MaxUint32, 2}}, out)
+
+	m.Delete(math.MaxUint32)
+	v, ok := m.Load(0)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), v)
+	_, ok = m.Load(math.MaxUint32)
+	assert.False(t, ok)
+}
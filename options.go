@@ -0,0 +1,56 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import "math"
+
+// Option represents an option which configures the map on construction.
+type Option func(*Map)
+
+// WithPresenceIndex maintains a bitset with one bit per slot, recording whether the
+// slot is occupied. This lets Load bail out without touching the backing array when
+// the home bucket of the key is empty, and lets Range skip blocks of 64 empty slots.
+// It costs an extra bit per slot and some bookkeeping on every insert and delete, so
+// it is only worth it for sparse maps with many misses.
+func WithPresenceIndex() Option {
+	return func(m *Map) {
+		m.index = make([]uint64, indexSize(m.Capacity()))
+	}
+}
+
+// WithProbeStride sets the number of slots skipped on every collision, which is one
+// by default. The stride must be odd, so that the probe sequence visits every slot
+// of the map regardless of its capacity. A larger stride spreads apart the keys of
+// clustered home buckets at the expense of cache locality. Quadratic probing is not
+// provided, since deleting without tombstones requires a fixed stride.
+func WithProbeStride(stride int) Option {
+	if stride <= 0 || stride&1 == 0 || stride > math.MaxInt32 {
+		panic("intmap: probe stride must be a positive odd number")
+	}
+
+	// Compute the inverse of the stride modulo 2^32 with Newton's method, which is
+	// used to convert a distance in slots back into a number of probes.
+	inverse := uint32(stride)
+	for i := 0; i < 4; i++ {
+		inverse *= 2 - uint32(stride)*inverse
+	}
+
+	return func(m *Map) {
+		m.step = 2 * uint32(stride)
+		m.inverse = inverse
+	}
+}
+
+// WithZeroOnDelete overwrites the value of a deleted key with zero, as well as the
+// backing arrays which are discarded when the map grows or shrinks, so that stale
+// values do not linger in memory. This is useful when the values are sensitive.
+func WithZeroOnDelete() Option {
+	return func(m *Map) {
+		m.zeroValues = true
+	}
+}
+
+// indexSize returns the number of words in the presence index for the capacity.
+func indexSize(capacity int) int {
+	return (capacity + 63) / 64
+}
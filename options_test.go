@@ -0,0 +1,208 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkPresenceIndex/plain-0%-8      	 8709955	       143.1 ns/op	       0 B/op	       0 allocs/op
+BenchmarkPresenceIndex/index-0%-8      	 8560484	       136.7 ns/op	       0 B/op	       0 allocs/op
+BenchmarkPresenceIndex/plain-50%-8     	14199734	        86.39 ns/op	       0 B/op	       0 allocs/op
+BenchmarkPresenceIndex/index-50%-8     	13605477	        94.31 ns/op	       0 B/op	       0 allocs/op
+BenchmarkPresenceIndex/plain-100%-8    	46246614	        27.89 ns/op	       0 B/op	       0 allocs/op
+BenchmarkPresenceIndex/index-100%-8    	39216733	        30.82 ns/op	       0 B/op	       0 allocs/op
+*/
+func BenchmarkPresenceIndex(b *testing.B) {
+	const count = 1000000
+	plain := New(count, .99)
+	index := New(count, .99, WithPresenceIndex())
+	for i := uint32(0); i < count; i++ {
+		plain.Store(i, i)
+		index.Store(i, i)
+	}
+
+	for _, rate := range []float64{0, 50, 100} {
+		for i, m := range []*Map{plain, index} {
+			name := []string{"plain", "index"}[i]
+			b.Run(fmt.Sprintf("%s-%v%%", name, rate), func(b *testing.B) {
+				shift := uint32(count - count*rate/100)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					m.Load(rand.Uint32N(count) + shift)
+				}
+			})
+		}
+	}
+}
+
+func TestPresenceIndex(t *testing.T) {
+	m := New(8, .9, WithPresenceIndex())
+	ref := make(map[uint32]uint32)
+	for i := 0; i < 100000; i++ {
+		key := rand.Uint32N(5000)
+		if rand.IntN(3) == 0 {
+			m.Delete(key)
+			delete(ref, key)
+			continue
+		}
+
+		m.Store(key, uint32(i))
+		ref[key] = uint32(i)
+	}
+
+	assert.NoError(t, m.Validate())
+	assert.NoError(t, m.Clone().Validate())
+	for k, v := range ref {
+		out, ok := m.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, out)
+	}
+
+	count := 0
+	m.Range(func(key, value uint32) bool {
+		assert.Equal(t, ref[key], value)
+		count++
+		return true
+	})
+	assert.Equal(t, len(ref), count)
+
+	m.Clear()
+	assert.NoError(t, m.Validate())
+	_, ok := m.Load(1)
+	assert.False(t, ok)
+}
+
+func TestPresenceIndexSparse(t *testing.T) {
+	m := New(10000, .9, WithPresenceIndex())
+	m.Store(1, 10)
+	m.Store(123456, 20)
+
+	keys := []uint32{}
+	m.Range(func(key, value uint32) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.ElementsMatch(t, []uint32{1, 123456}, keys)
+}
+
+func TestProbeStride(t *testing.T) {
+	for _, stride := range []int{1, 3, 7, 31, 1023} {
+		m := New(8, .9, WithProbeStride(stride))
+		assert.Equal(t, uint32(1), uint32(stride)*m.inverse)
+
+		ref := make(map[uint32]uint32)
+		for i := 0; i < 50000; i++ {
+			key := rand.Uint32N(5000)
+			if rand.IntN(3) == 0 {
+				m.Delete(key)
+				delete(ref, key)
+				continue
+			}
+
+			m.Store(key, uint32(i))
+			ref[key] = uint32(i)
+		}
+
+		assert.NoError(t, m.Validate())
+		assert.Equal(t, len(ref), m.Count())
+		for k, v := range ref {
+			out, ok := m.Load(k)
+			assert.True(t, ok)
+			assert.Equal(t, v, out)
+		}
+
+		// Walking the probe sequence from the home bucket reaches every key
+		m.RangeSlots(func(slot int, key, _ uint32, probe int) bool {
+			if slot >= 0 {
+				at := HomeBucket(key, m.Capacity())
+				for i := 0; i < probe; i++ {
+					at = m.NextSlot(at)
+				}
+				assert.Equal(t, slot, at)
+			}
+			return true
+		})
+	}
+}
+
+func TestProbeStrideCollisions(t *testing.T) {
+	for _, stride := range []int{1, 3, 7, 31} {
+		for _, size := range []int{100, 10000, 1000000} {
+			m := New(size, .75, WithProbeStride(stride))
+			r := rand.New(rand.NewPCG(uint64(stride), uint64(size)))
+			for i := 0; i < size; i++ {
+				m.Store(r.Uint32(), uint32(i))
+			}
+
+			sum, longest := 0, 0
+			m.RangeSlots(func(_ int, _, _ uint32, probe int) bool {
+				sum += probe
+				longest = max(longest, probe)
+				return true
+			})
+
+			assert.LessOrEqual(t, float64(sum)/float64(m.Count()), 2.0)
+			assert.LessOrEqual(t, longest, 64)
+		}
+	}
+}
+
+func TestProbeStrideInvalid(t *testing.T) {
+	for _, stride := range []int{0, -1, 2, 64} {
+		assert.Panics(t, func() {
+			WithProbeStride(stride)
+		})
+	}
+}
+
+func TestZeroOnDelete(t *testing.T) {
+	m := New(100, .9, WithZeroOnDelete())
+	for i := uint32(0); i < 100; i++ {
+		m.Store(i, 0xdead0000+i)
+	}
+
+	for i := uint32(0); i < 100; i += 2 {
+		m.Delete(i)
+	}
+
+	assert.NoError(t, m.Validate())
+	assert.Zero(t, m.freeVal)
+	raw := m.Raw()
+	for i := 0; i < len(raw); i += 2 {
+		if raw[i] == isFree {
+			assert.Zero(t, raw[i+1])
+		} else {
+			assert.Equal(t, 0xdead0000+raw[i], raw[i+1])
+		}
+	}
+}
+
+func TestZeroOnDeleteResize(t *testing.T) {
+	var released [][]uint32
+	onRelease = func(data []uint32) {
+		released = append(released, slices.Clone(data))
+	}
+	defer func() { onRelease = nil }()
+
+	m := New(8, .9, WithZeroOnDelete())
+	m.Store(1, 0xdead)
+	for i := uint32(2); i < 100; i++ {
+		m.Store(i, i)
+	}
+
+	// Every array is inspected before it goes back to the pool
+	assert.NotEmpty(t, released)
+	for _, data := range released {
+		assert.Equal(t, make([]uint32, len(data)), data)
+	}
+}
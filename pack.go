@@ -0,0 +1,34 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+// MaxPackedCount is the largest count which can be packed into a value.
+const MaxPackedCount = 1<<24 - 1
+
+// PackValue packs a count in the low 24 bits and flags in the high 8 bits of a value.
+// It panics if the count does not fit in 24 bits, rather than corrupting the flags.
+func PackValue(count uint32, flags uint8) uint32 {
+	if count > MaxPackedCount {
+		panic("intmap: packed count must fit in 24 bits")
+	}
+
+	return uint32(flags)<<24 | count
+}
+
+// UnpackValue returns the count and the flags of a value created with PackValue.
+func UnpackValue(value uint32) (count uint32, flags uint8) {
+	return value & MaxPackedCount, uint8(value >> 24)
+}
+
+// StorePacked sets the value for a key to the count and flags, packed together.
+func (m *Map) StorePacked(key, count uint32, flags uint8) {
+	m.Store(key, PackValue(count, flags))
+}
+
+// LoadPacked returns the count and flags stored for a key with StorePacked. The ok
+// result indicates whether value was found in the map.
+func (m *Map) LoadPacked(key uint32) (count uint32, flags uint8, ok bool) {
+	value, ok := m.Load(key)
+	count, flags = UnpackValue(value)
+	return
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackValue(t *testing.T) {
+	for _, count := range []uint32{0, 1, 12345, MaxPackedCount} {
+		for _, flags := range []uint8{0, 1, 0x80, 0xff} {
+			c, f := UnpackValue(PackValue(count, flags))
+			assert.Equal(t, count, c)
+			assert.Equal(t, flags, f)
+		}
+	}
+
+	assert.Panics(t, func() { PackValue(MaxPackedCount+1, 0) })
+}
+
+func TestStorePacked(t *testing.T) {
+	m := New(8, .9)
+	m.StorePacked(1, 500, 0x3)
+	m.StorePacked(0, MaxPackedCount, 0xff)
+
+	count, flags, ok := m.LoadPacked(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(500), count)
+	assert.Equal(t, uint8(0x3), flags)
+
+	count, flags, ok = m.LoadPacked(0)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(MaxPackedCount), count)
+	assert.Equal(t, uint8(0xff), flags)
+
+	_, _, ok = m.LoadPacked(2)
+	assert.False(t, ok)
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import "sync"
+
+// Pool is a pool of maps with the same fill factor, which amortizes allocations when
+// many short-lived maps are created and discarded.
+type Pool struct {
+	fillFactor float64
+	pool       sync.Pool
+}
+
+// NewPool returns a new pool of maps using the stated fillFactor.
+func NewPool(fillFactor float64) *Pool {
+	if fillFactor <= 0 || fillFactor >= 1 {
+		panic("intmap: fill factor must be in (0, 1)")
+	}
+
+	return &Pool{fillFactor: fillFactor}
+}
+
+// Get returns an empty map with room for at least size entries. Maps returned to the
+// pool are reused when possible, unless they are more than 4 times larger than the
+// requested size, in which case they are discarded.
+func (p *Pool) Get(size int) *Map {
+	size = max(size, 1)
+	capacity := arraySize(size, p.fillFactor)
+	if m, ok := p.pool.Get().(*Map); ok {
+		switch {
+		case m.Capacity() > 4*capacity:
+			release(m.data)
+		case m.Capacity() < capacity:
+			m.resize(capacity)
+			return m
+		default:
+			return m
+		}
+	}
+
+	return New(size, p.fillFactor)
+}
+
+// Put clears the map and returns it to the pool. The fill factor of the pool is
+// restored and the shrink policy is disabled, in case they were changed while the map
+// was borrowed. The map must have been obtained from this pool and must not be used
+// after it is returned.
+func (p *Pool) Put(m *Map) {
+	m.Clear()
+	m.minLoad = 0
+	m.fillFactor = float32(p.fillFactor)
+	m.threshold = thresholdOf(m.Capacity(), p.fillFactor)
+	p.pool.Put(m)
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkPool/new-8         	 1629897	       675.6 ns/op	    1120 B/op	       2 allocs/op
+BenchmarkPool/pool-8        	 2821428	       439.8 ns/op	       0 B/op	       0 allocs/op
+*/
+func BenchmarkPool(b *testing.B) {
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m := New(100, .9)
+			for k := uint32(0); k < 100; k++ {
+				m.Store(k, k)
+			}
+		}
+	})
+
+	b.Run("pool", func(b *testing.B) {
+		pool := NewPool(.9)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m := pool.Get(100)
+			for k := uint32(0); k < 100; k++ {
+				m.Store(k, k)
+			}
+			pool.Put(m)
+		}
+	})
+}
+
+func TestPool(t *testing.T) {
+	pool := NewPool(.9)
+	m := pool.Get(100)
+	assert.Equal(t, 128, m.Capacity())
+	for k := uint32(0); k < 100; k++ {
+		m.Store(k, k)
+	}
+
+	pool.Put(m)
+	assert.Equal(t, 0, m.Count())
+
+	// Reused maps must be empty and large enough
+	for _, size := range []int{0, 10, 100, 1000} {
+		m := pool.Get(size)
+		assert.Equal(t, 0, m.Count())
+		assert.GreaterOrEqual(t, m.Capacity(), arraySize(size, .9))
+		assert.LessOrEqual(t, m.Capacity(), 4*arraySize(size, .9))
+		assert.NoError(t, m.Validate())
+		pool.Put(m)
+	}
+
+	assert.Panics(t, func() {
+		NewPool(1)
+	})
+}
+
+func TestPoolPutRestoresSettings(t *testing.T) {
+	pool := NewPool(.9)
+	m := pool.Get(100)
+	m.SetFillFactor(.5)
+	m.SetShrinkPolicy(.2)
+	m.Store(1, 1)
+
+	// The map is put back with the settings of the pool
+	pool.Put(m)
+	assert.Zero(t, m.Count())
+	assert.Zero(t, m.minLoad)
+	assert.Equal(t, float32(.9), m.fillFactor)
+	assert.Equal(t, int(float32(m.Capacity())*.9), m.Threshold())
+}
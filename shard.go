@@ -0,0 +1,82 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import "math/bits"
+
+// Shard is a thread-safe map which is partitioned into several independently locked
+// shards, so that writers to different shards do not contend on a single lock.
+type Shard struct {
+	shards []Sync
+	shift  uint32
+}
+
+// NewShard returns a sharded map with the number of shards rounded up to a power of
+// two, where each shard is initialized for its share of size entries and uses the
+// stated fillFactor.
+func NewShard(shards, size int, fillFactor float64) *Shard {
+	if shards <= 0 || shards > 1<<16 {
+		panic("intmap: number of shards must be in [1, 65536]")
+	}
+
+	n := 1 << bits.Len(uint(shards-1))
+	s := &Shard{
+		shards: make([]Sync, n),
+		shift:  uint32(32 - bits.TrailingZeros(uint(n))),
+	}
+
+	for i := range s.shards {
+		s.shards[i].data = New(max(size/n, 1), fillFactor)
+	}
+	return s
+}
+
+// shardOf returns the shard for the key. It uses the high bits of a different hash
+// than the map itself, so the keys within a shard remain well distributed.
+func (s *Shard) shardOf(key uint32) *Sync {
+	if s.shift == 32 {
+		return &s.shards[0]
+	}
+
+	return &s.shards[(key*0x9e3779b9)>>s.shift]
+}
+
+// Load returns the value stored in the map for a key, or nil if no value is
+// present. The ok result indicates whether value was found in the map.
+func (s *Shard) Load(key uint32) (value uint32, ok bool) {
+	return s.shardOf(key).Load(key)
+}
+
+// Store sets the value for a key.
+func (s *Shard) Store(key, val uint32) {
+	s.shardOf(key).Store(key, val)
+}
+
+// Delete deletes the value for a key.
+func (s *Shard) Delete(key uint32) {
+	s.shardOf(key).Delete(key)
+}
+
+// Count returns number of key/value pairs in the map. Since each shard is counted
+// under its own lock, concurrent writes may or may not be reflected in the result.
+func (s *Shard) Count() (count int) {
+	for i := range s.shards {
+		count += s.shards[i].Count()
+	}
+	return
+}
+
+// RangeShards calls fn for each shard in order, with its index and its underlying map,
+// so that the shards can be processed independently. Each callback holds the read lock
+// of its shard, so it must not modify the map it is given nor write to the sharded map,
+// which would deadlock when the key belongs to the same shard. The callbacks run one
+// after another, callers which want to process the shards in parallel can hand off
+// the work to their own goroutines.
+func (s *Shard) RangeShards(fn func(shardIndex int, m *Map)) {
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.lock.RLock()
+		fn(i, shard.data)
+		shard.lock.RUnlock()
+	}
+}
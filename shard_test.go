@@ -0,0 +1,73 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShard(t *testing.T) {
+	s := NewShard(6, 1000, .9)
+	assert.Len(t, s.shards, 8)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := uint32(i * 1000); k < uint32(i*1000+1000); k++ {
+				s.Store(k, k)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	assert.Equal(t, 8000, s.Count())
+	for k := uint32(0); k < 8000; k++ {
+		v, ok := s.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, k, v)
+	}
+
+	s.Delete(0)
+	_, ok := s.Load(0)
+	assert.False(t, ok)
+	assert.Equal(t, 7999, s.Count())
+}
+
+func TestShardSingle(t *testing.T) {
+	s := NewShard(1, 10, .9)
+	s.Store(1, 10)
+	v, ok := s.Load(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(10), v)
+
+	assert.Panics(t, func() {
+		NewShard(0, 10, .9)
+	})
+}
+
+func TestRangeShards(t *testing.T) {
+	s := NewShard(4, 1000, .9)
+	for k := uint32(0); k < 1000; k++ {
+		s.Store(k, 1)
+	}
+
+	var total int
+	var order []int
+	s.RangeShards(func(shardIndex int, m *Map) {
+		assert.Greater(t, m.Count(), 0)
+		total += m.Count()
+		order = append(order, shardIndex)
+
+		m.RangeEach(func(key, _ uint32) {
+			assert.Same(t, &s.shards[shardIndex], s.shardOf(key))
+		})
+	})
+
+	assert.Equal(t, []int{0, 1, 2, 3}, order)
+	assert.Equal(t, 1000, total)
+}
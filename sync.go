@@ -2,7 +2,11 @@
 
 package intmap
 
-import "sync"
+import (
+	"errors"
+	"iter"
+	"sync"
+)
 
 // Sync is a thread-safe, map-like data-structure for int64s
 type Sync struct {
@@ -34,6 +38,14 @@ func (m *Sync) Store(key, val uint32) {
 	m.lock.Unlock()
 }
 
+// StoreReport sets the value for a key and returns whether the map grew as a result.
+func (m *Sync) StoreReport(key, val uint32) (grew bool) {
+	m.lock.Lock()
+	grew = m.data.StoreReport(key, val)
+	m.lock.Unlock()
+	return
+}
+
 // Delete deletes the value for a key.
 func (m *Sync) Delete(key uint32) {
 	m.lock.Lock()
@@ -60,13 +72,38 @@ func (m *Sync) LoadOrStore(key uint32, fn func() uint32) (value uint32, loaded b
 	// Load or store again, with exclusive lock now
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	if value, loaded = m.data.Load(key); !loaded {
-		value = fn()
+	return m.data.LoadOrStore(key, fn)
+}
+
+// LoadOrStoreValue returns the existing value for the key if present. Otherwise, it
+// stores and returns the given value. The loaded result is true if the value was
+// loaded, false if stored.
+func (m *Sync) LoadOrStoreValue(key, value uint32) (actual uint32, loaded bool) {
+	if actual, loaded = m.Load(key); loaded {
+		return // fast-path
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if actual, loaded = m.data.Load(key); !loaded {
+		actual = value
 		m.data.Store(key, value)
 	}
 	return
 }
 
+// GetOrCompute returns the existing value for the key if present. Otherwise, it calls
+// fn to compute the value, stores and returns it. The loaded result is true if the
+// value was loaded, false if computed.
+//
+// The computation is single-flight: fn is called under the write lock after checking
+// again for the key, so it runs at most once per missing key even when many goroutines
+// miss concurrently, and the others return the computed value. Since the lock is held,
+// fn must not access the map and should be quick, as it blocks every other access.
+func (m *Sync) GetOrCompute(key uint32, fn func() uint32) (value uint32, loaded bool) {
+	return m.LoadOrStore(key, fn)
+}
+
 // Range calls f sequentially for each key and value present in the map. If f
 // returns false, range stops the iteration.
 func (m *Sync) Range(f func(key, value uint32) bool) {
@@ -74,3 +111,127 @@ func (m *Sync) Range(f func(key, value uint32) bool) {
 	m.data.Range(f)
 	m.lock.RUnlock()
 }
+
+// Update atomically updates the value for a key. The function receives the current
+// value and whether it was present, and returns the new value and whether the key
+// should be kept. If keep is false, the key is deleted from the map. Note that fn
+// is called while the write lock is held and must not call back into the map.
+func (m *Sync) Update(key uint32, fn func(old uint32, loaded bool) (new uint32, keep bool)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	old, loaded := m.data.Load(key)
+	switch value, keep := fn(old, loaded); {
+	case keep:
+		m.data.Store(key, value)
+	case loaded:
+		m.data.Delete(key)
+	}
+}
+
+// ErrConcurrentResize is returned by RangeWeak when the map was resized or cleared
+// during the iteration.
+var ErrConcurrentResize = errors.New("intmap: map was resized during the iteration")
+
+// RangeWeak calls f sequentially for each key and value present in the map, without
+// blocking the writers for the whole iteration. The map is scanned in chunks, each of
+// them copied under a brief read lock, and f is called without holding the lock.
+//
+// The iteration is weakly consistent: entries stored or deleted concurrently may or
+// may not be visited, and since deleting shifts the entries which follow, an entry
+// which is present throughout may occasionally be missed or visited twice. If the map
+// is resized or cleared during the iteration, it stops and ErrConcurrentResize is
+// returned. If f returns false, range stops the iteration and nil is returned.
+func (m *Sync) RangeWeak(f func(key, value uint32) bool) error {
+	const chunk = 1024 // slots scanned under a single lock
+	var buffer []Entry
+
+	m.lock.RLock()
+	generation := m.data.generation
+	m.lock.RUnlock()
+
+	for pos := 0; ; pos += 2 * chunk {
+		buffer = buffer[:0]
+		m.lock.RLock()
+		data := m.data
+		if data.generation != generation {
+			m.lock.RUnlock()
+			return ErrConcurrentResize
+		}
+
+		if pos == 0 && data.hasFreeKey {
+			buffer = append(buffer, Entry{Key: isFree, Value: data.freeVal})
+		}
+
+		end := min(pos+2*chunk, len(data.data))
+		for i := data.next(pos); i < end; i = data.next(i + 2) {
+			buffer = append(buffer, Entry{Key: data.data[i], Value: data.data[i+1]})
+		}
+		last := end == len(data.data)
+		m.lock.RUnlock()
+
+		for _, e := range buffer {
+			if !f(e.Key, e.Value) {
+				return nil
+			}
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// TakeAll moves the contents of the map into a new, non thread-safe map which is
+// returned, leaving this map empty. This is done atomically under the write lock.
+func (m *Sync) TakeAll() (out *Map) {
+	m.lock.Lock()
+	out = m.data.TakeAll()
+	m.lock.Unlock()
+	return
+}
+
+// All returns an iterator over the key/value pairs of the map. The iterator works on
+// a snapshot taken under the read lock when the iteration starts, so the lock is not
+// held while the loop body runs and the map can be safely modified from within it.
+func (m *Sync) All() iter.Seq2[uint32, uint32] {
+	return func(yield func(key, value uint32) bool) {
+		m.lock.RLock()
+		snapshot := m.data.Clone()
+		m.lock.RUnlock()
+		snapshot.Range(yield)
+	}
+}
+
+// Clear removes all entries from the map under the write lock. The backing array is
+// retained, so that the map can be reused without allocating.
+func (m *Sync) Clear() {
+	m.lock.Lock()
+	m.data.Clear()
+	m.lock.Unlock()
+}
+
+// Add adds the delta to the value of a key under the write lock and returns the new
+// value. If the key is not present, it is stored with the delta as its value.
+func (m *Sync) Add(key, delta uint32) (value uint32) {
+	m.lock.Lock()
+	value = m.data.Add(key, delta)
+	m.lock.Unlock()
+	return
+}
+
+// Increment is equivalent to Add and is provided for counters. The read-modify-write
+// is performed under a single write lock and the incremented value is returned.
+func (m *Sync) Increment(key, delta uint32) uint32 {
+	return m.Add(key, delta)
+}
+
+// LoadAndReset returns the value for a key and resets it to zero under the write lock,
+// which is useful to drain counters. The key is kept in the map if it was present and
+// the loaded result reports whether it was.
+func (m *Sync) LoadAndReset(key uint32) (value uint32, loaded bool) {
+	m.lock.Lock()
+	value, loaded = m.data.LoadAndReset(key)
+	m.lock.Unlock()
+	return
+}
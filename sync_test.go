@@ -3,6 +3,8 @@
 package intmap
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -45,3 +47,281 @@ func TestSyncDelete(t *testing.T) {
 	_, ok := m.Load(1)
 	assert.False(t, ok)
 }
+
+func TestSyncUpdate(t *testing.T) {
+	m := sequentialSyncMap(10)
+	inc := func(old uint32, loaded bool) (uint32, bool) {
+		return old + 1, true
+	}
+
+	m.Update(1, inc)
+	m.Update(20, inc)
+
+	v, ok := m.Load(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(2), v)
+
+	v, ok = m.Load(20)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), v)
+	assert.Equal(t, 11, m.Count())
+}
+
+func TestSyncUpdateDelete(t *testing.T) {
+	m := sequentialSyncMap(10)
+	m.Update(1, func(old uint32, loaded bool) (uint32, bool) {
+		return 0, false
+	})
+	m.Update(20, func(old uint32, loaded bool) (uint32, bool) {
+		assert.False(t, loaded)
+		return 0, false
+	})
+
+	_, ok := m.Load(1)
+	assert.False(t, ok)
+	assert.Equal(t, 9, m.Count())
+}
+
+func TestSyncUpdateConcurrent(t *testing.T) {
+	m := NewSync(16, .9)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Update(uint32(j%10), func(old uint32, loaded bool) (uint32, bool) {
+					return old + 1, true
+				})
+			}
+		}()
+	}
+
+	wg.Wait()
+	for i := uint32(0); i < 10; i++ {
+		v, _ := m.Load(i)
+		assert.Equal(t, uint32(800), v)
+	}
+}
+
+func TestSyncTakeAll(t *testing.T) {
+	m := sequentialSyncMap(10)
+	out := m.TakeAll()
+	assert.Equal(t, 0, m.Count())
+	assert.Equal(t, 10, out.Count())
+}
+
+func TestSyncAll(t *testing.T) {
+	m := sequentialSyncMap(100)
+
+	count := 0
+	for key, value := range m.All() {
+		assert.Equal(t, key, value)
+		m.Delete(key) // must not deadlock
+		count++
+	}
+
+	assert.Equal(t, 100, count)
+	assert.Equal(t, 0, m.Count())
+}
+
+func TestSyncAllBreak(t *testing.T) {
+	m := sequentialSyncMap(100)
+
+	count := 0
+	for range m.All() {
+		if count++; count == 10 {
+			break
+		}
+	}
+	assert.Equal(t, 10, count)
+}
+
+func TestSyncClear(t *testing.T) {
+	m := sequentialSyncMap(1000)
+	m.Store(0, 10)
+	before := &m.data.Raw()[0]
+	capacity := m.data.Capacity()
+
+	m.Clear()
+	assert.Equal(t, 0, m.Count())
+	assert.Equal(t, capacity, m.data.Capacity())
+	assert.Same(t, before, &m.data.Raw()[0])
+	assert.False(t, m.data.hasFreeKey)
+
+	_, ok := m.Load(0)
+	assert.False(t, ok)
+	_, ok = m.Load(1)
+	assert.False(t, ok)
+}
+
+func TestSyncAdd(t *testing.T) {
+	m := NewSync(16, .9)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Add(uint32(j%10), 2)
+			}
+		}()
+	}
+
+	wg.Wait()
+	for i := uint32(0); i < 10; i++ {
+		v, _ := m.Load(i)
+		assert.Equal(t, uint32(1600), v)
+	}
+	assert.Equal(t, uint32(1602), m.Add(0, 2))
+}
+
+func TestSyncLoadAndReset(t *testing.T) {
+	m := NewSync(16, .9)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Increment(1, 1)
+			}
+		}()
+	}
+
+	// Drain the counter while it is being incremented, nothing should be lost
+	var total uint32
+	for i := 0; i < 100; i++ {
+		v, _ := m.LoadAndReset(1)
+		total += v
+	}
+
+	wg.Wait()
+	v, ok := m.LoadAndReset(1)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(4000), total+v)
+
+	v, ok = m.Load(1)
+	assert.True(t, ok)
+	assert.Zero(t, v)
+
+	_, ok = m.LoadAndReset(2)
+	assert.False(t, ok)
+	assert.Equal(t, 1, m.Count())
+
+	// A deleted key does not return its stale value
+	m.Store(0, 5)
+	m.Delete(0)
+	v, ok = m.LoadAndReset(0)
+	assert.False(t, ok)
+	assert.Zero(t, v)
+}
+
+func TestSyncLoadOrStoreValue(t *testing.T) {
+	m := NewSync(16, .9)
+	v, loaded := m.LoadOrStoreValue(1, 10)
+	assert.False(t, loaded)
+	assert.Equal(t, uint32(10), v)
+
+	v, loaded = m.LoadOrStoreValue(1, 20)
+	assert.True(t, loaded)
+	assert.Equal(t, uint32(10), v)
+}
+
+func TestSyncGetOrCompute(t *testing.T) {
+	const keys = 100
+	m := NewSync(16, .9)
+	var calls [keys]atomic.Int32
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for key := uint32(0); key < keys; key++ {
+				v, _ := m.GetOrCompute(key, func() uint32 {
+					calls[key].Add(1)
+					return key * 2
+				})
+				assert.Equal(t, key*2, v)
+			}
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+	for i := range calls {
+		assert.Equal(t, int32(1), calls[i].Load())
+	}
+}
+
+func TestSyncStoreReport(t *testing.T) {
+	m := NewSync(8, .9)
+	capacity, grew := m.data.Capacity(), 0
+	for i := uint32(0); i < 1000; i++ {
+		if m.StoreReport(i, i) {
+			assert.Greater(t, m.data.Capacity(), capacity)
+			capacity = m.data.Capacity()
+			grew++
+		}
+		assert.Equal(t, capacity, m.data.Capacity())
+	}
+
+	assert.Equal(t, 7, grew) // from 16 to 2048 slots
+	assert.False(t, m.StoreReport(1, 2))
+}
+
+func TestSyncRangeWeak(t *testing.T) {
+	m := sequentialSyncMap(10000)
+	sum, count := 0, 0
+	assert.NoError(t, m.RangeWeak(func(key, value uint32) bool {
+		sum += int(value)
+		count++
+		return true
+	}))
+	assert.Equal(t, 10000, count)
+	assert.Equal(t, 9999*10000/2, sum)
+
+	// Writing from within the callback does not deadlock
+	assert.NoError(t, m.RangeWeak(func(key, value uint32) bool {
+		m.Store(key, value+1)
+		return key != 0
+	}))
+	v, _ := m.Load(0)
+	assert.Equal(t, uint32(1), v)
+}
+
+func TestSyncRangeWeakResize(t *testing.T) {
+	m := sequentialSyncMap(10000)
+	next := uint32(10000)
+	err := m.RangeWeak(func(key, value uint32) bool {
+		for i := 0; i < 100; i++ {
+			m.Store(next, next)
+			next++
+		}
+		return true
+	})
+	assert.Equal(t, ErrConcurrentResize, err)
+}
+
+func TestSyncRangeWeakConcurrent(t *testing.T) {
+	m := sequentialSyncMap(10000)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := uint32(0); i < 10000; i++ {
+			m.Store(i, i+1)
+		}
+	}()
+
+	// Keys are never deleted, so each of them is visited at least once
+	seen := make(map[uint32]struct{})
+	assert.NoError(t, m.RangeWeak(func(key, value uint32) bool {
+		seen[key] = struct{}{}
+		return true
+	}))
+	assert.Len(t, seen, 10000)
+	<-done
+}
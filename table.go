@@ -0,0 +1,269 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"slices"
+	"unsafe"
+)
+
+// Unsigned is a constraint that permits any unsigned integer type as a key.
+type Unsigned interface {
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// Table is a generic map-like data-structure for unsigned integer keys and values of
+// any type. It uses the same open addressing scheme as Map, and the multiplier used
+// for hashing is chosen by the width of the key. Keys and values are kept in separate
+// arrays, so that no padding is needed when they differ in size. For example, a
+// Table[uint32, uint16] takes 6 bytes per slot and a Table[uint32, uint64] 12 bytes,
+// instead of 8 and 16 bytes if they were interleaved. The key zero is stored apart from
+// the arrays for every key width, the same way as in Map.
+type Table[K Unsigned, V any] struct {
+	keys       []K     // Keys of every slot
+	vals       []V     // Values of every slot
+	fillFactor float32 // Desired fill factor
+	threshold  int     // Threshold for resize
+	count      int     // Number of elements in the map
+	mask       uint64  // Mask to calculate the original bucket and collisions
+	freeVal    V       // Value of 'free' key
+	hasFreeKey bool    // Whether 'free' key exists
+}
+
+// NewTable returns a table initialized with n spaces and uses the stated fillFactor.
+// The table will grow as needed.
+func NewTable[K Unsigned, V any](size int, fillFactor float64) *Table[K, V] {
+	if fillFactor <= 0 || fillFactor >= 1 {
+		panic("intmap: fill factor must be in (0, 1)")
+	}
+	if size <= 0 {
+		panic("intmap: size must be positive")
+	}
+
+	capacity := arraySize(size, fillFactor)
+	return &Table[K, V]{
+		keys:       make([]K, capacity),
+		vals:       make([]V, capacity),
+		fillFactor: float32(fillFactor),
+		threshold:  int(thresholdOf(capacity, fillFactor)),
+		mask:       uint64(capacity - 1),
+	}
+}
+
+// Map64 is a map for 64-bit keys and values, such as snowflake identifiers which do
+// not fit into a Map. It is a Table which hashes the keys with a 64-bit fibonacci
+// multiplier, and provides the same Store, Load, Delete, Count, Range, Clear and
+// Clone methods as a Map.
+type Map64 = Table[uint64, uint64]
+
+// NewMap64 returns a map for 64-bit keys and values initialized with n spaces, which
+// uses the stated fillFactor. The map will grow as needed.
+func NewMap64(size int, fillFactor float64) *Map64 {
+	return NewTable[uint64, uint64](size, fillFactor)
+}
+
+// Capacity returns the capacity of the table.
+func (m *Table[K, V]) Capacity() int {
+	return len(m.keys)
+}
+
+// Count returns number of key/value pairs in the table.
+func (m *Table[K, V]) Count() int {
+	return m.count
+}
+
+// Load returns the value stored in the table for a key, or the zero value if no value
+// is present. The ok result indicates whether value was found in the table.
+func (m *Table[K, V]) Load(key K) (value V, ok bool) {
+	if key == isFree {
+		if m.hasFreeKey {
+			return m.freeVal, true
+		}
+		return
+	}
+
+	for ptr := hashOf(key, m.mask); ; ptr = (ptr + 1) & m.mask {
+		switch m.keys[ptr] {
+		case isFree:
+			return
+		case key:
+			return m.vals[ptr], true
+		}
+	}
+}
+
+// Store sets the value for a key.
+func (m *Table[K, V]) Store(key K, val V) {
+	if key == isFree {
+		if !m.hasFreeKey {
+			m.count++
+		}
+		m.hasFreeKey = true
+		m.freeVal = val
+		return
+	}
+
+	for ptr := hashOf(key, m.mask); ; ptr = (ptr + 1) & m.mask {
+		switch m.keys[ptr] {
+		case isFree:
+			m.keys[ptr] = key
+			m.vals[ptr] = val
+			if m.count >= m.threshold {
+				m.rehash()
+			} else {
+				m.count++
+			}
+			return
+		case key:
+			m.vals[ptr] = val
+			return
+		}
+	}
+}
+
+// upsert returns a pointer to the value for a key, inserting the zero value first if
+// the key is not present. The pointer is only valid until the table is modified.
+func (m *Table[K, V]) upsert(key K) *V {
+	if key == isFree {
+		if !m.hasFreeKey {
+			m.hasFreeKey = true
+			m.count++
+		}
+		return &m.freeVal
+	}
+
+	for ptr := hashOf(key, m.mask); ; ptr = (ptr + 1) & m.mask {
+		switch m.keys[ptr] {
+		case isFree:
+			m.keys[ptr] = key
+			if m.count >= m.threshold {
+				m.rehash()
+				return m.upsert(key)
+			}
+
+			m.count++
+			return &m.vals[ptr]
+		case key:
+			return &m.vals[ptr]
+		}
+	}
+}
+
+// Delete deletes the value for a key.
+func (m *Table[K, V]) Delete(key K) {
+	if key == isFree {
+		if m.hasFreeKey {
+			var zero V
+			m.hasFreeKey = false
+			m.freeVal = zero
+			m.count--
+		}
+		return
+	}
+
+	for ptr := hashOf(key, m.mask); ; ptr = (ptr + 1) & m.mask {
+		switch m.keys[ptr] {
+		case isFree:
+			return
+		case key:
+			m.shiftKeys(ptr)
+			m.count--
+			return
+		}
+	}
+}
+
+// Range calls f sequentially for each key and value present in the table. If fn
+// returns false, range stops the iteration.
+func (m *Table[K, V]) Range(fn func(key K, value V) bool) {
+	if m.hasFreeKey && !fn(isFree, m.freeVal) {
+		return
+	}
+
+	for i, key := range m.keys {
+		if key != isFree {
+			if !fn(key, m.vals[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Clone returns a copy of the table.
+func (m *Table[K, V]) Clone() *Table[K, V] {
+	clone := *m
+	clone.keys = slices.Clone(m.keys)
+	clone.vals = slices.Clone(m.vals)
+	return &clone
+}
+
+// Clear removes all entries from the table.
+func (m *Table[K, V]) Clear() {
+	var zero V
+	clear(m.keys)
+	clear(m.vals)
+	m.count = 0
+	m.hasFreeKey = false
+	m.freeVal = zero
+}
+
+// shiftKeys shifts entries with the same hash.
+func (m *Table[K, V]) shiftKeys(pos uint64) {
+	keys, vals := m.keys, m.vals
+	for {
+		last := pos
+		for pos = (last + 1) & m.mask; ; pos = (pos + 1) & m.mask {
+			if keys[pos] == isFree {
+				var zero V
+				keys[last] = isFree
+				vals[last] = zero
+				return
+			}
+
+			// The entry can be moved into the gap if the gap lies between its home
+			// bucket and its current position.
+			home := hashOf(keys[pos], m.mask)
+			if (last-home)&m.mask < (pos-home)&m.mask {
+				break
+			}
+		}
+		keys[last] = keys[pos]
+		vals[last] = vals[pos]
+	}
+}
+
+// rehash rehashes the key space and doubles the size of the table
+func (m *Table[K, V]) rehash() {
+	keys, vals := m.keys, m.vals
+	m.keys = make([]K, 2*len(keys))
+	m.vals = make([]V, 2*len(vals))
+	m.mask = uint64(len(m.keys) - 1)
+	m.threshold = int(thresholdOf(len(m.keys), float64(m.fillFactor)))
+	if m.hasFreeKey { // reset size
+		m.count = 1
+	} else {
+		m.count = 0
+	}
+
+	for i, key := range keys {
+		if key != isFree {
+			m.Store(key, vals[i])
+		}
+	}
+}
+
+// hashOf calculates the hash bucket for the key, choosing the multiplier by the
+// width of the key type. Keys up to 32 bits are placed exactly like in Map.
+func hashOf[K Unsigned](key K, mask uint64) uint64 {
+	if unsafe.Sizeof(key) > 4 {
+		return bucketOf64(uint64(key), mask)
+	}
+
+	return uint64(bucketOf(uint32(key), uint32(mask)) >> 1)
+}
+
+// bucketOf64 calculates the hash bucket for the 64-bit key using a fibonacci hash.
+func bucketOf64(key, mask uint64) uint64 {
+	h := key * 0x9e3779b97f4a7c15
+	return (h ^ h>>32) & mask
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2021-2024, Roman Atachiants
+
+package intmap
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type point struct {
+	X, Y int
+}
+
+func TestTableUint64(t *testing.T) {
+	m := NewTable[uint64, uint64](10, .9)
+	for i := uint64(0); i < 10000; i++ {
+		m.Store(i<<32|i, i)
+	}
+
+	assert.Equal(t, 10000, m.Count())
+	for i := uint64(0); i < 10000; i++ {
+		v, ok := m.Load(i<<32 | i)
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+
+		_, ok = m.Load(i << 32)
+		assert.Equal(t, i == 0, ok)
+	}
+
+	for i := uint64(0); i < 10000; i += 2 {
+		m.Delete(i<<32 | i)
+	}
+
+	assert.Equal(t, 5000, m.Count())
+	for i := uint64(0); i < 10000; i++ {
+		_, ok := m.Load(i<<32 | i)
+		assert.Equal(t, i%2 == 1, ok)
+	}
+}
+
+func TestMap64(t *testing.T) {
+	m := NewMap64(8, .9)
+	ref := make(map[uint64]uint64)
+	for i := 0; i < 100000; i++ {
+		key := rand.Uint64N(5000) << 40
+		if rand.IntN(3) == 0 {
+			m.Delete(key)
+			delete(ref, key)
+			continue
+		}
+
+		m.Store(key, key|uint64(i))
+		ref[key] = key | uint64(i)
+	}
+
+	clone := m.Clone()
+	assert.Equal(t, len(ref), m.Count())
+	m.Range(func(key, value uint64) bool {
+		assert.Equal(t, ref[key], value)
+		return true
+	})
+
+	m.Clear()
+	assert.Zero(t, m.Count())
+	for k, v := range ref {
+		out, ok := clone.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, out)
+	}
+}
+
+func TestTableStruct(t *testing.T) {
+	m := NewTable[uint16, point](10, .9)
+	for i := 0; i < 1000; i++ {
+		m.Store(uint16(i), point{X: i, Y: -i})
+	}
+
+	assert.Equal(t, 1000, m.Count())
+	v, ok := m.Load(0)
+	assert.True(t, ok)
+	assert.Equal(t, point{}, v)
+
+	v, ok = m.Load(999)
+	assert.True(t, ok)
+	assert.Equal(t, point{X: 999, Y: -999}, v)
+
+	m.Delete(0)
+	m.Delete(0)
+	_, ok = m.Load(0)
+	assert.False(t, ok)
+	assert.Equal(t, 999, m.Count())
+}
+
+func TestTableRandom(t *testing.T) {
+	m := NewTable[uint32, uint32](10, .9)
+	ref := make(map[uint32]uint32)
+	for i := 0; i < 100000; i++ {
+		key := rand.Uint32N(5000)
+		if rand.IntN(3) == 0 {
+			m.Delete(key)
+			delete(ref, key)
+			continue
+		}
+
+		m.Store(key, uint32(i))
+		ref[key] = uint32(i)
+	}
+
+	assert.Equal(t, len(ref), m.Count())
+	for k, v := range ref {
+		out, ok := m.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, out)
+	}
+}
+
+func TestTableThreshold(t *testing.T) {
+	for _, fill := range []float64{.01, .1, .5, .99} {
+		m := NewTable[uint32, uint32](1, fill)
+		assert.GreaterOrEqual(t, m.threshold, 1)
+
+		for k := uint32(1); k <= 100; k++ {
+			m.Store(k, k)
+			assert.GreaterOrEqual(t, m.threshold, 1)
+		}
+		assert.Equal(t, 100, m.Count())
+	}
+}
+
+func TestTableRangeClone(t *testing.T) {
+	m := NewTable[uint8, string](10, .9)
+	m.Store(0, "zero")
+	m.Store(1, "one")
+	m.Store(2, "two")
+
+	clone := m.Clone()
+	m.Clear()
+	assert.Equal(t, 0, m.Count())
+	assert.Equal(t, 3, clone.Count())
+
+	values := []string{}
+	clone.Range(func(key uint8, value string) bool {
+		values = append(values, value)
+		return true
+	})
+	assert.ElementsMatch(t, []string{"zero", "one", "two"}, values)
+
+	count := 0
+	clone.Range(func(key uint8, value string) bool {
+		count++
+		return false
+	})
+	assert.Equal(t, 1, count)
+}
+
+func TestTablePlacement(t *testing.T) {
+	m := NewTable[uint32, uint32](10, .6)
+	assert.Equal(t, 32, m.Capacity())
+	for i := 0; i < 1000; i++ {
+		key := rand.Uint32()
+		assert.Equal(t, uint64(HomeBucket(key, m.Capacity())), hashOf(key, m.mask))
+	}
+}
+
+func TestInvalidNewTable(t *testing.T) {
+	assert.Panics(t, func() {
+		NewTable[uint32, uint32](10, 0)
+	})
+
+	assert.Panics(t, func() {
+		NewTable[uint32, uint32](0, .99)
+	})
+}
+
+func TestTableValueWidths(t *testing.T) {
+	testTableValues(t, NewTable[uint32, uint16](8, .75), math.MaxUint16)
+	testTableValues(t, NewTable[uint32, uint32](8, .75), math.MaxUint32)
+	testTableValues(t, NewTable[uint32, uint64](8, .75), math.MaxUint64)
+}
+
+func testTableValues[V Unsigned](t *testing.T, m *Table[uint32, V], maxValue V) {
+	ref := make(map[uint32]V)
+	for i := 0; i < 50000; i++ {
+		key := rand.Uint32N(5000)
+		if rand.IntN(3) == 0 {
+			m.Delete(key)
+			delete(ref, key)
+			continue
+		}
+
+		value := maxValue - V(i)
+		m.Store(key, value)
+		ref[key] = value
+	}
+
+	assert.Equal(t, len(ref), m.Count())
+	for k, v := range ref {
+		out, ok := m.Load(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, out)
+	}
+
+	// Fill the table and check the probe distances of random keys
+	m.Clear()
+	for i := 0; i < 100000; i++ {
+		m.Store(rand.Uint32(), V(i))
+	}
+
+	sum, longest := 0, 0
+	for i, key := range m.keys {
+		if key != isFree {
+			probe := int((uint64(i) - hashOf(key, m.mask)) & m.mask)
+			sum += probe
+			longest = max(longest, probe)
+		}
+	}
+
+	assert.LessOrEqual(t, float64(sum)/float64(m.Count()), 2.5)
+	assert.LessOrEqual(t, longest, 128)
+}
+
+func TestTableKeyWidths(t *testing.T) {
+	testTableKeys(t, NewTable[uint8, uint8](8, .9))
+	testTableKeys(t, NewTable[uint16, uint16](8, .9))
+	testTableKeys(t, NewTable[uint32, uint32](8, .9))
+	testTableKeys(t, NewTable[uint64, uint64](8, .9))
+	testTableKeys(t, NewTable[uint, uint](8, .9))
+}
+
+func testTableKeys[K Unsigned](t *testing.T, m *Table[K, K]) {
+	keys := []K{0, 1, ^K(0), ^K(0) >> 1, 100}
+	for _, key := range keys {
+		m.Store(key, key+1)
+	}
+
+	assert.Equal(t, len(keys), m.Count())
+	assert.True(t, m.hasFreeKey)
+	for _, key := range keys {
+		v, ok := m.Load(key)
+		assert.True(t, ok)
+		assert.Equal(t, key+1, v)
+	}
+
+	// Updating and reading existing keys does not allocate
+	assert.Zero(t, testing.AllocsPerRun(100, func() {
+		for _, key := range keys {
+			m.Store(key, key)
+			m.Load(key)
+		}
+	}))
+
+	m.Delete(0)
+	_, ok := m.Load(0)
+	assert.False(t, ok)
+	assert.False(t, m.hasFreeKey)
+	assert.Equal(t, len(keys)-1, m.Count())
+}